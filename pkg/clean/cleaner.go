@@ -16,10 +16,8 @@ type CleanerGroup struct {
 // Clean runs all cleaners in the group
 func (c *CleanerGroup) Clean(ctx context.Context, ID string) error {
 	failed := 0
-	for _, job := range c.Jobs {
-		err := job.Clean(ctx, ID)
-		if err != nil {
-			goapp.Log.Error().Err(err).Send()
+	for _, r := range c.run(ctx, ID, false) {
+		if r.Error != "" {
 			failed++
 		}
 	}
@@ -29,6 +27,40 @@ func (c *CleanerGroup) Clean(ctx context.Context, ID string) error {
 	return nil
 }
 
+// run calls all jobs, a failed job does not stop others
+func (c *CleanerGroup) run(ctx context.Context, ID string, dryRun bool) []CleanerResult {
+	res := make([]CleanerResult, 0, len(c.Jobs))
+	for _, job := range c.Jobs {
+		res = append(res, runJob(ctx, job, ID, dryRun))
+	}
+	return res
+}
+
+// runCleaner runs the cleaner or each job of the CleanerGroup, in dry run jobs' Plan is called instead of Clean
+func runCleaner(ctx context.Context, c Cleaner, ID string, dryRun bool) []CleanerResult {
+	if g, ok := c.(*CleanerGroup); ok {
+		return g.run(ctx, ID, dryRun)
+	}
+	return []CleanerResult{runJob(ctx, c, ID, dryRun)}
+}
+
+func runJob(ctx context.Context, c Cleaner, ID string, dryRun bool) CleanerResult {
+	res := CleanerResult{Cleaner: CleanerName(c)}
+	var err error
+	if dryRun {
+		res.Plan, err = planJob(ctx, c, ID)
+	} else {
+		err = c.Clean(ctx, ID)
+	}
+	if err != nil {
+		if err != errNoPlan {
+			goapp.Log.Error().Err(err).Str("cleaner", res.Cleaner).Str("ID", ID).Send()
+		}
+		res.Error = err.Error()
+	}
+	return res
+}
+
 // NewFileCleaners creates file cleaners based on provided paths
 func NewFileCleaners(fs string, patterns []string) ([]*LocalFile, error) {
 	result := make([]*LocalFile, 0)
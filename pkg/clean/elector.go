@@ -0,0 +1,87 @@
+package clean
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Elector selects one instance to run the named job, e.g. when a service has several replicas.
+// The leadership is a lease, other instances take over the job when the leader stops renewing it
+type Elector interface {
+	// Acquire makes the instance the job's leader or renews its leadership,
+	// false is returned if other instance leads
+	Acquire(ctx context.Context, job string) (bool, error)
+	// Release gives up the leadership
+	Release(ctx context.Context, job string) error
+	// Lease is the leadership's expiration time if not renewed
+	Lease() time.Duration
+}
+
+// MemoryElector elects leaders among members sharing it in one process, use it in tests
+type MemoryElector struct {
+	lease time.Duration
+	now   func() time.Time
+
+	m       sync.Mutex
+	leaders map[string]memoryLease
+}
+
+type memoryLease struct {
+	owner   string
+	expires time.Time
+}
+
+// NewMemoryElector creates MemoryElector instance
+func NewMemoryElector(lease time.Duration) (*MemoryElector, error) {
+	if lease <= 0 {
+		return nil, errors.Errorf("wrong lease %v", lease)
+	}
+	return &MemoryElector{lease: lease, now: time.Now, leaders: map[string]memoryLease{}}, nil
+}
+
+// Member returns the Elector for the instance identified by owner
+func (e *MemoryElector) Member(owner string) Elector {
+	return &memoryMember{e: e, owner: owner}
+}
+
+func (e *MemoryElector) acquire(job, owner string) bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now := e.now()
+	if l, ok := e.leaders[job]; ok && l.owner != owner && l.expires.After(now) {
+		return false
+	}
+	e.leaders[job] = memoryLease{owner: owner, expires: now.Add(e.lease)}
+	return true
+}
+
+func (e *MemoryElector) release(job, owner string) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if l, ok := e.leaders[job]; ok && l.owner == owner {
+		delete(e.leaders, job)
+	}
+}
+
+type memoryMember struct {
+	e     *MemoryElector
+	owner string
+}
+
+func (m *memoryMember) Acquire(ctx context.Context, job string) (bool, error) {
+	return m.e.acquire(job, m.owner), nil
+}
+
+func (m *memoryMember) Release(ctx context.Context, job string) error {
+	m.e.release(job, m.owner)
+	return nil
+}
+
+func (m *memoryMember) Lease() time.Duration {
+	return m.e.lease
+}
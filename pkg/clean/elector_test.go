@@ -0,0 +1,180 @@
+package clean
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMemoryElector(t *testing.T) {
+	got, err := NewMemoryElector(time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, got)
+	_, err = NewMemoryElector(0)
+	assert.NotNil(t, err)
+}
+
+func TestMemoryElector(t *testing.T) {
+	ctx := test.Ctx(t)
+	e, err := NewMemoryElector(time.Minute)
+	assert.Nil(t, err)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	m1, m2 := e.Member("1"), e.Member("2")
+
+	ok, _ := m1.Acquire(ctx, "job")
+	assert.True(t, ok)
+	ok, _ = m2.Acquire(ctx, "job")
+	assert.False(t, ok)
+	ok, _ = m2.Acquire(ctx, "job2")
+	assert.True(t, ok)
+	ok, _ = m1.Acquire(ctx, "job")
+	assert.True(t, ok)
+
+	// leader died
+	now = now.Add(2 * time.Minute)
+	ok, _ = m2.Acquire(ctx, "job")
+	assert.True(t, ok)
+	ok, _ = m1.Acquire(ctx, "job")
+	assert.False(t, ok)
+
+	// released by the leader only
+	assert.Nil(t, m1.Release(ctx, "job"))
+	ok, _ = m1.Acquire(ctx, "job")
+	assert.False(t, ok)
+	assert.Nil(t, m2.Release(ctx, "job"))
+	ok, _ = m1.Acquire(ctx, "job")
+	assert.True(t, ok)
+}
+
+func TestTimer_doClean_Leader(t *testing.T) {
+	e, err := NewMemoryElector(time.Minute)
+	assert.Nil(t, err)
+	newTestTimer := func(owner string) (*Timer, *testCleaner) {
+		cl := &testCleaner{}
+		return newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl,
+			IDsProvider: newIDsProviderMock([]string{"1"}, false), Elector: e.Member(owner)}), cl
+	}
+	t1, cl1 := newTestTimer("1")
+	t2, cl2 := newTestTimer("2")
+
+	t1.doClean(test.Ctx(t))
+	t2.doClean(test.Ctx(t))
+	assert.Equal(t, []string{"1"}, cl1.ids)
+	assert.Empty(t, cl2.ids)
+	assert.Nil(t, t2.LastReport())
+
+	// the leader stops
+	t1.resign(test.Ctx(t))
+	t2.doClean(test.Ctx(t))
+	t1.doClean(test.Ctx(t))
+	assert.Equal(t, []string{"1"}, cl1.ids)
+	assert.Equal(t, []string{"1"}, cl2.ids)
+}
+
+type testElector struct {
+	results []bool
+	err     error
+	calls   int
+	lease   time.Duration
+}
+
+func (e *testElector) Acquire(ctx context.Context, job string) (bool, error) {
+	e.calls++
+	if e.calls > len(e.results) {
+		return e.results[len(e.results)-1], e.err
+	}
+	return e.results[e.calls-1], e.err
+}
+
+func (e *testElector) Release(ctx context.Context, job string) error {
+	return nil
+}
+
+func (e *testElector) Lease() time.Duration {
+	return e.lease
+}
+
+func TestTimer_doClean_LostLeadership(t *testing.T) {
+	cl := &testCleaner{f: func(ctx context.Context, id string) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	}}
+	el := &testElector{results: []bool{true, true, false}, lease: 60 * time.Millisecond}
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl,
+		IDsProvider: newIDsProviderMock([]string{"1", "2", "3", "4", "5"}, false), Elector: el})
+	tm.doClean(test.Ctx(t))
+	assert.Less(t, len(cl.ids), 5)
+	assert.NotEmpty(t, tm.pending)
+
+	// not a leader, carried IDs are dropped
+	tm.doClean(test.Ctx(t))
+	assert.Empty(t, tm.pending)
+}
+
+func TestTimer_doClean_LeaseShorterThanRun(t *testing.T) {
+	e, err := NewMemoryElector(30 * time.Millisecond)
+	assert.Nil(t, err)
+	other := e.Member("2")
+	taken := false
+	cl := &testCleaner{f: func(ctx context.Context, id string) error {
+		time.Sleep(25 * time.Millisecond)
+		if ok, _ := other.Acquire(ctx, "clean"); ok {
+			taken = true
+		}
+		return nil
+	}}
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl,
+		IDsProvider: newIDsProviderMock([]string{"1", "2", "3", "4", "5"}, false), Elector: e.Member("1")})
+	tm.doClean(test.Ctx(t))
+	assert.False(t, taken)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, cl.ids)
+	assert.Empty(t, tm.pending)
+}
+
+func TestTimer_Start_LeaseShorterThanRunEvery(t *testing.T) {
+	e, err := NewMemoryElector(45 * time.Millisecond)
+	assert.Nil(t, err)
+	newTestTimer := func(owner string) (*Timer, *testCleaner) {
+		cl := &testCleaner{}
+		return newTimer(&TimerData{RunEvery: 150 * time.Millisecond, Cleaner: cl,
+			IDsProvider: newIDsProviderMock([]string{"1"}, false), Elector: e.Member(owner)}), cl
+	}
+	t1, cl1 := newTestTimer("1")
+	t2, cl2 := newTestTimer("2")
+
+	ctx1, cancel1 := context.WithCancel(test.Ctx(t))
+	ctx2, cancel2 := context.WithCancel(test.Ctx(t))
+	done1 := t1.Start(ctx1)
+	// staggered replica ticks between the leader's runs, after the lease would expire without renewing
+	time.Sleep(75 * time.Millisecond)
+	done2 := t2.Start(ctx2)
+	time.Sleep(400 * time.Millisecond)
+	// stop the follower first, the leader resigns on stop
+	cancel2()
+	<-done2
+	cancel1()
+	<-done1
+
+	assert.GreaterOrEqual(t, len(cl1.ids), 3)
+	assert.Empty(t, cl2.ids)
+}
+
+func Test_renewInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, renewInterval(30*time.Second))
+	assert.Equal(t, time.Nanosecond, renewInterval(2))
+}
+
+func TestTimer_doClean_ElectorError(t *testing.T) {
+	cl := &testCleaner{}
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl,
+		IDsProvider: newIDsProviderMock([]string{"1"}, false), Elector: &testElector{results: []bool{true},
+			err: errors.New("olia")}})
+	tm.doClean(test.Ctx(t))
+	assert.Empty(t, cl.ids)
+	assert.Nil(t, tm.LastReport())
+}
@@ -1,6 +1,7 @@
 package clean
 
 import (
+	"context"
 	"os"
 	"path"
 	"path/filepath"
@@ -37,12 +38,26 @@ func NewLocalFile(storagePath string, pattern string) (*LocalFile, error) {
 }
 
 // Clean removes files matching the pattern
-func (fs *LocalFile) Clean(ID string) error {
+func (fs *LocalFile) Clean(ctx context.Context, ID string) error {
 	fp := fs.getPath(ID)
 	goapp.Log.Info().Msgf("Removing %s", fp)
 	return remove(fp)
 }
 
+// Plan returns files matching the pattern
+func (fs *LocalFile) Plan(ctx context.Context, ID string) (*Plan, error) {
+	files, err := filepath.Glob(fs.getPath(ID))
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{Count: int64(len(files)), Items: files}, nil
+}
+
+// String returns the cleaner's name for reports
+func (fs *LocalFile) String() string {
+	return "file:" + fs.getPath("{ID}")
+}
+
 func remove(fn string) error {
 	files, err := filepath.Glob(fn)
 	if err != nil {
@@ -1,11 +1,19 @@
 package clean
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/airenas/async-api/internal/pkg/test"
 	"github.com/stretchr/testify/assert"
 )
 
+var (
+	_ Cleaner = (*LocalFile)(nil)
+	_ Planner = (*LocalFile)(nil)
+)
+
 func TestNewLocalFile(t *testing.T) {
 	type args struct {
 		storagePath string
@@ -66,3 +74,19 @@ func TestLocalFile_getPath(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalFile_Plan(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"1.txt", "1.wav", "2.txt"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, f), []byte("olia"), 0644))
+	}
+	fs, err := NewLocalFile(dir, "{ID}.*")
+	assert.Nil(t, err)
+	got, err := fs.Plan(test.Ctx(t), "1")
+	assert.Nil(t, err)
+	assert.Equal(t, &Plan{Count: 2, Items: []string{filepath.Join(dir, "1.txt"), filepath.Join(dir, "1.wav")}}, got)
+	// nothing removed
+	_, err = os.Stat(filepath.Join(dir, "1.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "file:"+dir+"/{ID}.*", fs.String())
+}
@@ -0,0 +1,130 @@
+package clean
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Planner is implemented by cleaners able to tell what Clean would remove, it is used in dry run
+type Planner interface {
+	// Plan returns the number of items and the items' names Clean would remove, nothing is removed
+	Plan(ctx context.Context, ID string) (*Plan, error)
+}
+
+// Plan describes what the cleaner would remove
+type Plan struct {
+	Count int64    `json:"count"`
+	Items []string `json:"items,omitempty"`
+}
+
+// Report is the clean run summary
+type Report struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	DryRun   bool          `json:"dryRun,omitempty"`
+	// Expired is the number of IDs to clean, including carried over ones
+	Expired int `json:"expired"`
+	// Cleaned is the number of IDs cleaned (planned in dry run) by at least one cleaner
+	Cleaned int `json:"cleaned"`
+	// Failed is the number of IDs all cleaners failed for
+	Failed int `json:"failed"`
+	// Skipped is the number of IDs no cleaner could plan in dry run, see Planner
+	Skipped int `json:"skipped,omitempty"`
+	// CarriedOver is the number of IDs not started in the run
+	CarriedOver int `json:"carriedOver"`
+	// Failures is the number of failed calls per cleaner
+	Failures map[string]int `json:"failures,omitempty"`
+	// Unplannable is the number of IDs per cleaner not implementing Planner in dry run
+	Unplannable map[string]int `json:"unplannable,omitempty"`
+	// Error is the IDs provider's error
+	Error string `json:"error,omitempty"`
+	// IDs keeps results per ID in dry run
+	IDs []IDReport `json:"ids,omitempty"`
+}
+
+// IDReport keeps the results of all cleaners for the ID
+type IDReport struct {
+	ID       string          `json:"ID"`
+	Cleaners []CleanerResult `json:"cleaners"`
+}
+
+// CleanerResult is one cleaner's result for the ID
+type CleanerResult struct {
+	Cleaner string `json:"cleaner"`
+	// Plan is set in dry run
+	Plan  *Plan  `json:"plan,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// errNoPlan is returned in dry run for cleaners not implementing Planner
+var errNoPlan = errors.New("dry run is not supported")
+
+// CleanerName returns the cleaner's name used in reports: String() if the cleaner implements fmt.Stringer, or its type
+func CleanerName(c Cleaner) string {
+	if s, ok := c.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+func planJob(ctx context.Context, c Cleaner, ID string) (*Plan, error) {
+	p, ok := c.(Planner)
+	if !ok {
+		return nil, errNoPlan
+	}
+	return p.Plan(ctx, ID)
+}
+
+// runReport collects results from parallel cleanings
+type runReport struct {
+	m   sync.Mutex
+	rep Report
+}
+
+func newRunReport(dryRun bool) *runReport {
+	return &runReport{rep: Report{Started: time.Now(), DryRun: dryRun, Failures: map[string]int{},
+		Unplannable: map[string]int{}}}
+}
+
+func (r *runReport) add(ID string, res []CleanerResult) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	failed, noPlan := 0, 0
+	for _, cr := range res {
+		switch cr.Error {
+		case "":
+		case errNoPlan.Error():
+			r.rep.Unplannable[cr.Cleaner]++
+			noPlan++
+		default:
+			r.rep.Failures[cr.Cleaner]++
+			failed++
+		}
+	}
+	switch {
+	case len(res) > 0 && failed+noPlan == len(res) && failed > 0:
+		r.rep.Failed++
+	case len(res) > 0 && noPlan == len(res):
+		r.rep.Skipped++
+	default:
+		r.rep.Cleaned++
+	}
+	if r.rep.DryRun {
+		r.rep.IDs = append(r.rep.IDs, IDReport{ID: ID, Cleaners: res})
+	}
+}
+
+func (r *runReport) finish(carried int) *Report {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.rep.CarriedOver = carried
+	r.rep.Duration = time.Since(r.rep.Started)
+	res := r.rep
+	return &res
+}
@@ -0,0 +1,103 @@
+package clean
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPlanner struct {
+	name    string
+	plan    *Plan
+	err     error
+	cleaned int
+}
+
+func (p *testPlanner) Clean(ctx context.Context, ID string) error {
+	p.cleaned++
+	return p.err
+}
+
+func (p *testPlanner) Plan(ctx context.Context, ID string) (*Plan, error) {
+	return p.plan, p.err
+}
+
+func (p *testPlanner) String() string {
+	return p.name
+}
+
+func TestCleanerName(t *testing.T) {
+	assert.Equal(t, "p1", CleanerName(&testPlanner{name: "p1"}))
+	assert.Equal(t, "*clean.testCleaner", CleanerName(&testCleaner{}))
+}
+
+func TestTimer_doClean_Report(t *testing.T) {
+	p1 := &testPlanner{name: "p1", plan: &Plan{Count: 2, Items: []string{"a", "b"}}}
+	p2 := &testPlanner{name: "p2", err: errors.New("olia")}
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: &CleanerGroup{Jobs: []Cleaner{p1, p2}},
+		IDsProvider: newIDsProviderMock([]string{"1", "2"}, false)})
+	assert.Nil(t, tm.LastReport())
+
+	tm.doClean(test.Ctx(t))
+	got := tm.LastReport()
+	assert.NotNil(t, got)
+	assert.Equal(t, 2, got.Expired)
+	assert.Equal(t, 2, got.Cleaned)
+	assert.Equal(t, 0, got.Failed)
+	assert.Equal(t, map[string]int{"p2": 2}, got.Failures)
+	assert.Empty(t, got.IDs)
+	assert.Equal(t, 2, p1.cleaned)
+
+	p1.err = errors.New("olia")
+	tm.doClean(test.Ctx(t))
+	got = tm.LastReport()
+	assert.Equal(t, 0, got.Cleaned)
+	assert.Equal(t, 2, got.Failed)
+	assert.Equal(t, map[string]int{"p1": 2, "p2": 2}, got.Failures)
+}
+
+func TestTimer_doClean_DryRun(t *testing.T) {
+	p1 := &testPlanner{name: "p1", plan: &Plan{Count: 2, Items: []string{"a", "b"}}}
+	cl := &testCleaner{}
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: &CleanerGroup{Jobs: []Cleaner{p1, cl}},
+		IDsProvider: newIDsProviderMock([]string{"1"}, false), DryRun: true})
+
+	tm.doClean(test.Ctx(t))
+	got := tm.LastReport()
+	assert.True(t, got.DryRun)
+	assert.Equal(t, 1, got.Cleaned)
+	assert.Equal(t, 0, got.Skipped)
+	assert.Empty(t, got.Failures)
+	assert.Equal(t, map[string]int{"*clean.testCleaner": 1}, got.Unplannable)
+	assert.Equal(t, []IDReport{{ID: "1", Cleaners: []CleanerResult{{Cleaner: "p1", Plan: p1.plan},
+		{Cleaner: "*clean.testCleaner", Error: errNoPlan.Error()}}}}, got.IDs)
+	assert.Equal(t, 0, p1.cleaned)
+	assert.Empty(t, cl.ids)
+}
+
+func TestTimer_doClean_DryRunUnplannable(t *testing.T) {
+	cl := &testCleaner{}
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: &CleanerGroup{Jobs: []Cleaner{cl}},
+		IDsProvider: newIDsProviderMock([]string{"1", "2"}, false), DryRun: true})
+
+	tm.doClean(test.Ctx(t))
+	got := tm.LastReport()
+	assert.Equal(t, 0, got.Cleaned)
+	assert.Equal(t, 0, got.Failed)
+	assert.Equal(t, 2, got.Skipped)
+	assert.Equal(t, map[string]int{"*clean.testCleaner": 2}, got.Unplannable)
+	assert.Empty(t, cl.ids)
+}
+
+func TestTimer_doClean_ProviderError(t *testing.T) {
+	tm := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: newCleanMock(false),
+		IDsProvider: newIDsProviderMock(nil, true)})
+	tm.doClean(test.Ctx(t))
+	got := tm.LastReport()
+	assert.Equal(t, "olia", got.Error)
+	assert.Equal(t, 0, got.Expired)
+}
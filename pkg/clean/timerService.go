@@ -2,6 +2,7 @@ package clean
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/airenas/go-app/pkg/goapp"
@@ -23,10 +24,38 @@ type TimerData struct {
 	RunEvery    time.Duration
 	Cleaner     Cleaner
 	IDsProvider OldIDsProvider
+	// Workers is the number of IDs cleaned in parallel, defaults to 1
+	Workers int
+	// IDTimeout limits one ID cleaning, defaults to 60s
+	IDTimeout time.Duration
+	// RunTimeout limits starting new cleanings in one run, 0 - no limit.
+	// IDs not started are carried over to the next run
+	RunTimeout time.Duration
+	// RateLimit is the max number of IDs cleaned per second, 0 - no limit. Max value is 1e9, one ID per nanosecond
+	RateLimit float64
+	// DryRun reports what cleaners would remove without removing anything, see Planner
+	DryRun bool
+	// Elector lets only the Job's leader run cleaning. The leadership is checked before each run
+	// and renewed every Elector.Lease()/3 for the whole life of the timer, so it does not expire between runs
+	Elector Elector
+	// Job is the job's name for the Elector, defaults to "clean"
+	Job string
 }
 
+// maxRateLimit is the rate limit with a nanosecond interval between IDs
+const maxRateLimit = float64(time.Second)
+
 // StartCleanTimer starts timer in loop for doing clean tasks
 func StartCleanTimer(ctx context.Context, data *TimerData) (<-chan struct{}, error) {
+	t, err := NewCleanTimer(data)
+	if err != nil {
+		return nil, err
+	}
+	return t.Start(ctx), nil
+}
+
+// NewCleanTimer creates Timer for doing clean tasks, use it to access run reports
+func NewCleanTimer(data *TimerData) (*Timer, error) {
 	if data.RunEvery < time.Minute {
 		return nil, errors.Errorf("wrong run every duration %s, expected >= 1m", data.RunEvery.String())
 	}
@@ -36,49 +65,294 @@ func StartCleanTimer(ctx context.Context, data *TimerData) (<-chan struct{}, err
 	if data.IDsProvider == nil {
 		return nil, errors.Errorf("no IDs provider")
 	}
+	if data.Workers < 0 {
+		return nil, errors.Errorf("wrong workers %d, expected >= 0", data.Workers)
+	}
+	if data.IDTimeout < 0 || data.RunTimeout < 0 {
+		return nil, errors.Errorf("wrong ID timeout %v or run timeout %v", data.IDTimeout, data.RunTimeout)
+	}
+	if data.RateLimit < 0 || data.RateLimit > maxRateLimit {
+		return nil, errors.Errorf("wrong rate limit %v, expected [0, %v]", data.RateLimit, maxRateLimit)
+	}
+	if data.Elector != nil && data.Elector.Lease() <= 0 {
+		return nil, errors.Errorf("wrong elector lease %v", data.Elector.Lease())
+	}
 
-	return startLoop(ctx, data), nil
+	return newTimer(data), nil
+}
+
+// Timer runs clean tasks periodically, it keeps IDs carried over from the previous run and the last run report
+type Timer struct {
+	data    TimerData
+	pending []string
+	leading bool
+
+	m    sync.Mutex
+	last *Report
+}
+
+func newTimer(data *TimerData) *Timer {
+	res := &Timer{data: *data}
+	if res.data.Workers == 0 {
+		res.data.Workers = 1
+	}
+	if res.data.IDTimeout == 0 {
+		res.data.IDTimeout = time.Second * 60
+	}
+	if res.data.Job == "" {
+		res.data.Job = "clean"
+	}
+	return res
 }
 
 func startLoop(ctx context.Context, data *TimerData) <-chan struct{} {
-	goapp.Log.Info().Msgf("Starting timer service every %v", data.RunEvery)
+	return newTimer(data).Start(ctx)
+}
+
+// Start runs cleaning on startup and then every RunEvery,
+// the returned channel is closed when the timer stops after ctx is canceled
+func (t *Timer) Start(ctx context.Context) <-chan struct{} {
+	goapp.Log.Info().Int("workers", t.data.Workers).Float64("rate", t.data.RateLimit).Bool("dryRun", t.data.DryRun).
+		Msgf("Starting timer service every %v", t.data.RunEvery)
 	res := make(chan struct{}, 2)
 	go func() {
 		defer close(res)
-		serviceLoop(ctx, data)
+		t.serviceLoop(ctx)
 	}()
 	return res
 }
 
-func serviceLoop(ctx context.Context, data *TimerData) {
-	ticker := time.NewTicker(data.RunEvery)
+func (t *Timer) serviceLoop(ctx context.Context) {
+	ticker := time.NewTicker(t.data.RunEvery)
+	var renew <-chan time.Time
+	if t.data.Elector != nil {
+		renewTicker := time.NewTicker(renewInterval(t.data.Elector.Lease()))
+		defer renewTicker.Stop()
+		renew = renewTicker.C
+	}
 	// run on startup
-	doClean(ctx, data)
+	t.doClean(ctx)
 	for {
 		select {
 		case <-ticker.C:
-			ctxInt, cf := context.WithTimeout(ctx, time.Second*60)
-			doClean(ctxInt, data)
-			cf()
+			t.doClean(ctx)
+		case <-renew:
+			t.renew(ctx)
 		case <-ctx.Done():
 			ticker.Stop()
+			t.resign(ctx)
 			goapp.Log.Info().Msgf("Stopped timer service")
 			return
 		}
 	}
 }
 
-func doClean(ctx context.Context, data *TimerData) {
-	goapp.Log.Info().Msg("Running cleaning")
-	ids, err := data.IDsProvider.GetExpired(ctx)
+// LastReport returns the last run report, nil if there was no run yet
+func (t *Timer) LastReport() *Report {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.last
+}
+
+func (t *Timer) doClean(ctx context.Context) {
+	if !t.lead(ctx) {
+		return
+	}
+	goapp.Log.Info().Bool("dryRun", t.data.DryRun).Msg("Running cleaning")
+	rep := newRunReport(t.data.DryRun)
+	runCtx, cf := ctx, func() {}
+	if t.data.RunTimeout > 0 {
+		runCtx, cf = context.WithTimeout(ctx, t.data.RunTimeout)
+	}
+	defer cf()
+	if t.data.Elector != nil {
+		var stop func()
+		runCtx, stop = t.keepLeading(runCtx)
+		defer stop()
+	}
+
+	ids, err := t.data.IDsProvider.GetExpired(runCtx)
 	if err != nil {
 		goapp.Log.Error().Err(err).Send()
+		rep.rep.Error = err.Error()
+	}
+	ids = mergeIDs(t.pending, ids)
+	rep.rep.Expired = len(ids)
+	goapp.Log.Info().Int("count", len(ids)).Int("carried", len(t.pending)).Msg("Got IDs to clean")
+	t.pending = t.cleanAll(ctx, runCtx, ids, rep)
+	if len(t.pending) > 0 {
+		goapp.Log.Warn().Int("count", len(t.pending)).Msg("Not all IDs cleaned, carry over to the next run")
+	}
+	res := rep.finish(len(t.pending))
+	goapp.Log.Info().Int("cleaned", res.Cleaned).Int("failed", res.Failed).Int("skipped", res.Skipped).
+		Dur("duration", res.Duration).
+		Msg("Cleaning finished")
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.last = res
+}
+
+// lead returns true if the instance may run cleaning, the run is skipped on the Elector's error
+func (t *Timer) lead(ctx context.Context) bool {
+	if t.data.Elector == nil {
+		return true
+	}
+	ok, err := t.data.Elector.Acquire(ctx, t.data.Job)
+	if err != nil {
+		goapp.Log.Error().Err(err).Str("job", t.data.Job).Msg("can't check leadership, skip run")
+		return false
+	}
+	if !ok {
+		if t.leading {
+			goapp.Log.Warn().Str("job", t.data.Job).Msg("Lost leadership")
+		}
+		goapp.Log.Debug().Str("job", t.data.Job).Msg("Not a leader, skip run")
+		t.leading = false
+		// the leader finds the IDs itself
+		t.pending = nil
+		return false
+	}
+	if !t.leading {
+		goapp.Log.Info().Str("job", t.data.Job).Msg("Became leader")
+	}
+	t.leading = true
+	return true
+}
+
+// renew keeps the leader's lease between runs, other instances take over only if the leader stops
+func (t *Timer) renew(ctx context.Context) {
+	if t.leading {
+		t.lead(ctx)
+	}
+}
+
+// keepLeading renews the leadership while running, the returned ctx is canceled if the leadership is lost,
+// so no new cleanings are started
+func (t *Timer) keepLeading(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(renewInterval(t.data.Elector.Lease()))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ok, err := t.data.Elector.Acquire(ctx, t.data.Job)
+				if err != nil {
+					goapp.Log.Warn().Err(err).Str("job", t.data.Job).Msg("can't renew leadership")
+					continue
+				}
+				if !ok {
+					goapp.Log.Warn().Str("job", t.data.Job).Msg("Lost leadership, stopping run")
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+	}
+}
+
+// renewInterval returns the interval to renew the lease before it expires, at least 1ns
+func renewInterval(lease time.Duration) time.Duration {
+	if lease < 3 {
+		return 1
+	}
+	return lease / 3
+}
+
+// resign releases the leadership on stop, so other instances take over without waiting for the lease to expire
+func (t *Timer) resign(ctx context.Context) {
+	if t.data.Elector == nil || !t.leading {
+		return
+	}
+	t.leading = false
+	if err := t.data.Elector.Release(context.WithoutCancel(ctx), t.data.Job); err != nil {
+		goapp.Log.Warn().Err(err).Str("job", t.data.Job).Msg("can't release leadership")
+	}
+}
+
+// cleanAll cleans IDs in parallel until runCtx is done, returns IDs not started.
+// Started cleanings are limited by IDTimeout only, so they are not canceled at the end of the run
+func (t *Timer) cleanAll(ctx, runCtx context.Context, ids []string, rep *runReport) []string {
+	start := func(id string) { t.clean(ctx, id, rep) }
+	if t.data.Workers > 1 && len(ids) > 1 {
+		jobs := make(chan string)
+		var wg sync.WaitGroup
+		for i := 0; i < t.data.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for id := range jobs {
+					t.clean(ctx, id, rep)
+				}
+			}()
+		}
+		defer wg.Wait()
+		defer close(jobs)
+		start = func(id string) { jobs <- id }
 	}
-	goapp.Log.Info().Int("count", len(ids)).Msg("Got IDs to clean")
-	for _, id := range ids {
-		err = data.Cleaner.Clean(ctx, id)
-		if err != nil {
-			goapp.Log.Error().Err(err).Send()
+
+	var limit <-chan time.Time
+	if t.data.RateLimit > 0 {
+		ticker := time.NewTicker(rateInterval(t.data.RateLimit))
+		defer ticker.Stop()
+		limit = ticker.C
+	}
+	for i, id := range ids {
+		// the first ID is always started, so the run makes progress even if GetExpired took the whole run time
+		if i > 0 && wait(runCtx, limit) != nil {
+			return ids[i:]
 		}
+		start(id)
+	}
+	return nil
+}
+
+// rateInterval returns the interval between IDs for the rate limit, at least 1ns
+func rateInterval(rate float64) time.Duration {
+	res := time.Duration(float64(time.Second) / rate)
+	if res < 1 {
+		return 1
 	}
+	return res
+}
+
+// wait waits for the rate limit tick, returns error if ctx is done
+func wait(ctx context.Context, limit <-chan time.Time) error {
+	if limit != nil {
+		select {
+		case <-limit:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+func (t *Timer) clean(ctx context.Context, id string, rep *runReport) {
+	ctx, cf := context.WithTimeout(ctx, t.data.IDTimeout)
+	defer cf()
+	rep.add(id, runCleaner(ctx, t.data.Cleaner, id, t.data.DryRun))
+}
+
+// mergeIDs returns carried over IDs first, duplicates are dropped
+func mergeIDs(carried, ids []string) []string {
+	res := make([]string, 0, len(carried)+len(ids))
+	found := make(map[string]bool, len(carried)+len(ids))
+	for _, l := range [][]string{carried, ids} {
+		for _, id := range l {
+			if !found[id] {
+				found[id] = true
+				res = append(res, id)
+			}
+		}
+	}
+	return res
 }
@@ -2,6 +2,8 @@ package clean
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,6 +33,19 @@ func TestStartCleanTimer(t *testing.T) {
 			IDsProvider: newIDsProviderMock(nil, false)}}, wantErr: true},
 		{name: "Fail", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute, Cleaner: newCleanMock(false),
 			IDsProvider: nil}}, wantErr: true},
+		{name: "Wrong workers", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute,
+			Cleaner: newCleanMock(false), IDsProvider: newIDsProviderMock(nil, false), Workers: -1}}, wantErr: true},
+		{name: "Wrong timeout", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute,
+			Cleaner: newCleanMock(false), IDsProvider: newIDsProviderMock(nil, false), IDTimeout: -1}}, wantErr: true},
+		{name: "Wrong rate", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute,
+			Cleaner: newCleanMock(false), IDsProvider: newIDsProviderMock(nil, false), RateLimit: -1}}, wantErr: true},
+		{name: "Too high rate", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute,
+			Cleaner: newCleanMock(false), IDsProvider: newIDsProviderMock(nil, false), RateLimit: 2e9}}, wantErr: true},
+		{name: "Wrong lease", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute,
+			Cleaner: newCleanMock(false), IDsProvider: newIDsProviderMock(nil, false), Elector: &testElector{}}},
+			wantErr: true},
+		{name: "Max rate", args: args{ctx: context.Background(), data: &TimerData{RunEvery: time.Minute,
+			Cleaner: newCleanMock(false), IDsProvider: newIDsProviderMock(nil, false), RateLimit: 1e9}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -122,3 +137,97 @@ func newIDsProviderMock(resIDs []string, fail bool) *mockIDsProvider {
 	res.On("GetExpired", mock.Anything).Return(resIDs, err)
 	return res
 }
+
+func Test_newTimer(t *testing.T) {
+	l := newTimer(&TimerData{RunEvery: time.Hour})
+	assert.Equal(t, 1, l.data.Workers)
+	assert.Equal(t, time.Minute, l.data.IDTimeout)
+	assert.Equal(t, time.Duration(0), l.data.RunTimeout)
+}
+
+type testCleaner struct {
+	f     func(ctx context.Context, id string) error
+	m     sync.Mutex
+	ids   []string
+	count atomic.Int32
+	max   atomic.Int32
+}
+
+func (c *testCleaner) Clean(ctx context.Context, id string) error {
+	n := c.count.Add(1)
+	defer c.count.Add(-1)
+	if n > c.max.Load() {
+		c.max.Store(n)
+	}
+	c.m.Lock()
+	c.ids = append(c.ids, id)
+	c.m.Unlock()
+	if c.f != nil {
+		return c.f(ctx, id)
+	}
+	return nil
+}
+
+func TestTimer_cleanAll_Parallel(t *testing.T) {
+	cl := &testCleaner{f: func(ctx context.Context, id string) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}}
+	l := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl, Workers: 3})
+	got := l.cleanAll(test.Ctx(t), test.Ctx(t), []string{"1", "2", "3", "4", "5", "6"}, newRunReport(false))
+	assert.Empty(t, got)
+	assert.Equal(t, 6, len(cl.ids))
+	assert.Equal(t, int32(3), cl.max.Load())
+}
+
+func TestTimer_cleanAll_IDTimeout(t *testing.T) {
+	cl := &testCleaner{f: func(ctx context.Context, id string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	l := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl, IDTimeout: 10 * time.Millisecond})
+	start := time.Now()
+	got := l.cleanAll(test.Ctx(t), test.Ctx(t), []string{"1", "2"}, newRunReport(false))
+	assert.Empty(t, got)
+	assert.Equal(t, []string{"1", "2"}, cl.ids)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestTimer_cleanAll_RateLimit(t *testing.T) {
+	cl := &testCleaner{}
+	l := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl, Workers: 4, RateLimit: 50})
+	start := time.Now()
+	got := l.cleanAll(test.Ctx(t), test.Ctx(t), []string{"1", "2", "3", "4", "5"}, newRunReport(false))
+	assert.Empty(t, got)
+	assert.Equal(t, 5, len(cl.ids))
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+func Test_rateInterval(t *testing.T) {
+	assert.Equal(t, 20*time.Millisecond, rateInterval(50))
+	assert.Equal(t, time.Nanosecond, rateInterval(1e9))
+	assert.Equal(t, time.Nanosecond, rateInterval(1e12))
+}
+
+func TestTimer_doClean_CarryOver(t *testing.T) {
+	cl := &testCleaner{f: func(ctx context.Context, id string) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	}}
+	ids := newIDsProviderMock([]string{"1", "2", "3"}, false)
+	l := newTimer(&TimerData{RunEvery: time.Minute, Cleaner: cl, IDsProvider: ids,
+		RunTimeout: 50 * time.Millisecond})
+	l.doClean(test.Ctx(t))
+	assert.Equal(t, []string{"3"}, l.pending)
+	assert.Equal(t, []string{"1", "2"}, cl.ids)
+
+	l.data.RunTimeout = time.Second
+	l.doClean(test.Ctx(t))
+	assert.Empty(t, l.pending)
+	assert.Equal(t, []string{"1", "2", "3", "1", "2"}, cl.ids)
+}
+
+func Test_mergeIDs(t *testing.T) {
+	assert.Equal(t, []string{"3", "1", "2"}, mergeIDs([]string{"3", "1"}, []string{"1", "2", "3"}))
+	assert.Equal(t, []string{}, mergeIDs(nil, nil))
+}
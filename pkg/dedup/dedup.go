@@ -0,0 +1,67 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/airenas/async-api/pkg/rabbit"
+)
+
+// State is the key's processing state
+type State int
+
+const (
+	// New - the key was not seen or its lease expired, the caller owns it now
+	New State = iota
+	// InProgress - the key is being processed by someone else
+	InProgress
+	// Done - the key was processed
+	Done
+)
+
+// ErrInProgress is returned by Handler when the same message is being processed by other worker.
+// It wraps rabbit.ErrRetryLater, so the consumer redelivers the message after a delay without counting the attempt
+var ErrInProgress = fmt.Errorf("message is in progress: %w", rabbit.ErrRetryLater)
+
+// Store keeps processed keys
+type Store interface {
+	// Begin marks the key as in progress for the lease and returns New.
+	// The key is not changed if it is InProgress or Done, the state is returned
+	Begin(ctx context.Context, key string) (State, error)
+	// Complete marks the key as done, it is kept for the TTL
+	Complete(ctx context.Context, key string) error
+	// Release drops the in progress mark, so the key can be processed again
+	Release(ctx context.Context, key string) error
+}
+
+// Options keeps Store settings
+type Options struct {
+	// TTL is the time the done key is kept, defaults to 24h. Make it longer than the max redelivery time
+	TTL time.Duration
+	// Lease is the time the key is in progress, defaults to 5m.
+	// After it expires the key is taken over, e.g. when the worker died
+	Lease time.Duration
+}
+
+// Validate checks options and sets defaults
+func (o *Options) Validate() error {
+	if o.TTL < 0 {
+		return fmt.Errorf("wrong TTL %v", o.TTL)
+	}
+	if o.Lease < 0 {
+		return fmt.Errorf("wrong lease %v", o.Lease)
+	}
+	if o.TTL == 0 {
+		o.TTL = 24 * time.Hour
+	}
+	if o.Lease == 0 {
+		o.Lease = 5 * time.Minute
+	}
+	return nil
+}
+
+// Key returns the store key for the message ID processed by the handler
+func Key(handler, id string) string {
+	return handler + ":" + id
+}
@@ -0,0 +1,50 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/async-api/pkg/rabbit"
+	"github.com/airenas/go-app/pkg/goapp"
+)
+
+// Handler returns handler calling next once per message ID and handler name.
+// Done messages are skipped (acked), messages in progress by other worker fail with ErrInProgress
+// and are redelivered after the consumer's retry delay.
+// If next fails, the key is released, so the redelivered message is processed again.
+// Messages without ID are passed to next
+func Handler[T any, PT interface {
+	*T
+	messages.Message
+}](store Store, name string, next rabbit.HandlerFunc[T]) rabbit.HandlerFunc[T] {
+	return func(ctx context.Context, msg *T) error {
+		id := PT(msg).GetID()
+		if id == "" {
+			return next(ctx, msg)
+		}
+		key := Key(name, id)
+		st, err := store.Begin(ctx, key)
+		if err != nil {
+			return fmt.Errorf("can't check %s: %w", key, err)
+		}
+		switch st {
+		case Done:
+			goapp.Log.Info().Str("key", key).Msg("duplicate message, skip")
+			return nil
+		case InProgress:
+			return fmt.Errorf("%s: %w", key, ErrInProgress)
+		}
+		if err := next(ctx, msg); err != nil {
+			if rErr := store.Release(context.WithoutCancel(ctx), key); rErr != nil {
+				goapp.Log.Warn().Err(rErr).Str("key", key).Msg("can't release")
+			}
+			return err
+		}
+		// the message is processed, failing here would process it again
+		if err := store.Complete(context.WithoutCancel(ctx), key); err != nil {
+			goapp.Log.Warn().Err(err).Str("key", key).Msg("can't mark done")
+		}
+		return nil
+	}
+}
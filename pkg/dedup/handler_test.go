@@ -0,0 +1,88 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/async-api/pkg/rabbit"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStore struct {
+	state    State
+	err      error
+	done     []string
+	released []string
+}
+
+func (s *testStore) Begin(ctx context.Context, key string) (State, error) {
+	return s.state, s.err
+}
+
+func (s *testStore) Complete(ctx context.Context, key string) error {
+	s.done = append(s.done, key)
+	return nil
+}
+
+func (s *testStore) Release(ctx context.Context, key string) error {
+	s.released = append(s.released, key)
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		id           string
+		state        State
+		storeErr     error
+		handlerErr   error
+		wantCalled   bool
+		wantDone     []string
+		wantReleased []string
+		wantErr      error
+	}{
+		{name: "New", id: "1", state: New, wantCalled: true, wantDone: []string{"h:1"}},
+		{name: "Done", id: "1", state: Done},
+		{name: "In progress", id: "1", state: InProgress, wantErr: rabbit.ErrRetryLater},
+		{name: "Fails", id: "1", state: New, handlerErr: errors.New("olia"), wantCalled: true,
+			wantReleased: []string{"h:1"}},
+		{name: "Store fails", id: "1", storeErr: errors.New("olia")},
+		{name: "No ID", state: Done, wantCalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &testStore{state: tt.state, err: tt.storeErr}
+			called := false
+			h := Handler(st, "h", func(ctx context.Context, msg *messages.QueueMessage) error {
+				called = true
+				return tt.handlerErr
+			})
+			err := h(test.Ctx(t), &messages.QueueMessage{ID: tt.id})
+			wantErr := tt.wantErr != nil || tt.handlerErr != nil || tt.storeErr != nil
+			assert.Equal(t, wantErr, err != nil)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantCalled, called)
+			assert.Equal(t, tt.wantDone, st.done)
+			assert.Equal(t, tt.wantReleased, st.released)
+		})
+	}
+}
+
+func TestHandler_Memory(t *testing.T) {
+	s, err := NewMemory(Options{})
+	assert.Nil(t, err)
+	calls := 0
+	h := Handler(s, "h", func(ctx context.Context, msg *messages.QueueMessage) error {
+		calls++
+		return nil
+	})
+	assert.Nil(t, h(test.Ctx(t), &messages.QueueMessage{ID: "1"}))
+	assert.Nil(t, h(test.Ctx(t), &messages.QueueMessage{ID: "1"}))
+	assert.Nil(t, h(test.Ctx(t), &messages.QueueMessage{ID: "2"}))
+	assert.Equal(t, 2, calls)
+}
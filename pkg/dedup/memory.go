@@ -0,0 +1,81 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps keys in memory, use it for a single instance service or tests.
+// Expired keys are removed on Begin
+type Memory struct {
+	opt Options
+	now func() time.Time
+
+	m         sync.Mutex
+	keys      map[string]entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	done    bool
+	expires time.Time
+}
+
+// NewMemory creates Memory instance
+func NewMemory(opt Options) (*Memory, error) {
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+	return &Memory{opt: opt, now: time.Now, keys: map[string]entry{}}, nil
+}
+
+// Begin marks the key as in progress
+func (s *Memory) Begin(ctx context.Context, key string) (State, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	if e, ok := s.keys[key]; ok && e.expires.After(now) {
+		if e.done {
+			return Done, nil
+		}
+		return InProgress, nil
+	}
+	s.keys[key] = entry{expires: now.Add(s.opt.Lease)}
+	return New, nil
+}
+
+// Complete marks the key as done
+func (s *Memory) Complete(ctx context.Context, key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.keys[key] = entry{done: true, expires: s.now().Add(s.opt.TTL)}
+	return nil
+}
+
+// Release drops the in progress mark
+func (s *Memory) Release(ctx context.Context, key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if e, ok := s.keys[key]; ok && !e.done {
+		delete(s.keys, key)
+	}
+	return nil
+}
+
+// sweep removes expired keys, it runs at most once per lease
+func (s *Memory) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.opt.Lease {
+		return
+	}
+	s.lastSweep = now
+	for k, e := range s.keys {
+		if !e.expires.After(now) {
+			delete(s.keys, k)
+		}
+	}
+}
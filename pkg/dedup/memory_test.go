@@ -0,0 +1,75 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Store = (*Memory)(nil)
+
+func TestNewMemory(t *testing.T) {
+	tests := []struct {
+		name      string
+		opt       Options
+		wantTTL   time.Duration
+		wantLease time.Duration
+		wantErr   bool
+	}{
+		{name: "Defaults", wantTTL: 24 * time.Hour, wantLease: 5 * time.Minute},
+		{name: "Set", opt: Options{TTL: time.Hour, Lease: time.Minute}, wantTTL: time.Hour, wantLease: time.Minute},
+		{name: "Wrong TTL", opt: Options{TTL: -1}, wantErr: true},
+		{name: "Wrong lease", opt: Options{Lease: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMemory(tt.opt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewMemory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantTTL, got.opt.TTL)
+				assert.Equal(t, tt.wantLease, got.opt.Lease)
+			}
+		})
+	}
+}
+
+func TestMemory(t *testing.T) {
+	ctx := test.Ctx(t)
+	s, err := NewMemory(Options{TTL: time.Hour, Lease: time.Minute})
+	assert.Nil(t, err)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	st, err := s.Begin(ctx, "k")
+	assert.Nil(t, err)
+	assert.Equal(t, New, st)
+	st, _ = s.Begin(ctx, "k")
+	assert.Equal(t, InProgress, st)
+
+	assert.Nil(t, s.Release(ctx, "k"))
+	st, _ = s.Begin(ctx, "k")
+	assert.Equal(t, New, st)
+
+	assert.Nil(t, s.Complete(ctx, "k"))
+	assert.Nil(t, s.Release(ctx, "k"))
+	st, _ = s.Begin(ctx, "k")
+	assert.Equal(t, Done, st)
+
+	// lease expired
+	st, _ = s.Begin(ctx, "k1")
+	assert.Equal(t, New, st)
+	now = now.Add(2 * time.Minute)
+	st, _ = s.Begin(ctx, "k1")
+	assert.Equal(t, New, st)
+
+	// TTL expired, keys are swept
+	now = now.Add(2 * time.Hour)
+	st, _ = s.Begin(ctx, "k")
+	assert.Equal(t, New, st)
+	assert.Equal(t, 1, len(s.keys))
+}
@@ -2,6 +2,7 @@ package file
 
 import (
 	"errors"
+	"io/fs"
 	"testing"
 
 	"github.com/airenas/async-api/internal/pkg/test/mocks"
@@ -29,6 +30,13 @@ func TestLoaderFailsOnNoOpen(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestLoaderFails_NotExist(t *testing.T) {
+	fileLoader, err := NewLocalLoader(t.TempDir())
+	assert.Nil(t, err)
+	_, err = fileLoader.Load("file")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
 func TestLoaderChecksDirOnInit(t *testing.T) {
 	_, err := NewLocalLoader("./")
 	assert.Nil(t, err)
@@ -67,6 +67,18 @@ func (fs LocalSaver) Save(name string, reader io.Reader) error {
 	return nil
 }
 
+// Delete removes file from disk, a missing file is not an error
+func (fs LocalSaver) Delete(name string) error {
+	if strings.Contains(name, "..") {
+		return errors.New("wrong path " + name)
+	}
+	fileName := filepath.Join(fs.StoragePath, name)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "can not delete file %s", fileName)
+	}
+	return nil
+}
+
 func openFile(fileName string) (WriterCloser, error) {
 	dir := filepath.Dir(fileName)
 	if err := checkCreateDir(dir); err != nil {
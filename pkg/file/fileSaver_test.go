@@ -3,6 +3,8 @@ package file
 import (
 	"bytes"
 	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -44,6 +46,19 @@ func TestFails_WrongPath(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestDeletes(t *testing.T) {
+	dir := t.TempDir()
+	fileSaver, err := NewLocalSaver(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, fileSaver.Save("d/file", strings.NewReader("body")))
+	assert.Nil(t, fileSaver.Delete("d/file"))
+	_, err = os.Stat(filepath.Join(dir, "d/file"))
+	assert.True(t, os.IsNotExist(err))
+	// missing file
+	assert.Nil(t, fileSaver.Delete("d/file"))
+	assert.NotNil(t, fileSaver.Delete("../file"))
+}
+
 func TestChecksDirOnInit(t *testing.T) {
 	_, err := NewLocalSaver("./")
 	assert.Nil(t, err)
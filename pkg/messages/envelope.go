@@ -0,0 +1,139 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Envelope wraps the message with its type and schema version
+type Envelope struct {
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Producer  string          `json:"producer,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Validator checks the decoded message
+type Validator func(msg Message) error
+
+// DecodeError is returned when the message can't be decoded into the registered type
+type DecodeError struct {
+	Type    string
+	Version int
+	Err     error
+}
+
+// Error implements error interface
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("can't decode message %s(v%d): %v", e.Type, e.Version, e.Err)
+}
+
+// Unwrap returns the reason
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnknownSchema is the reason of DecodeError for not registered type and version
+var ErrUnknownSchema = errors.New("unknown message schema")
+
+type schemaKey struct {
+	msgType string
+	version int
+}
+
+type schema struct {
+	goType   reflect.Type
+	validate Validator
+}
+
+// Registry maps message type and version to Go types and validators
+type Registry struct {
+	producer string
+	m        sync.RWMutex // struct field mutex
+	schemas  map[schemaKey]schema
+	goTypes  map[reflect.Type]schemaKey
+}
+
+// NewRegistry creates registry, producer is written to the envelopes
+func NewRegistry(producer string) *Registry {
+	return &Registry{producer: producer, schemas: map[schemaKey]schema{}, goTypes: map[reflect.Type]schemaKey{}}
+}
+
+// Register maps type and version to the Go type of sample, sample must be a pointer, e.g. &QueueMessage{}.
+// validate is optional. Encode uses the highest registered version of the Go type
+func (r *Registry) Register(msgType string, version int, sample Message, validate Validator) error {
+	if msgType == "" {
+		return fmt.Errorf("no type")
+	}
+	if version < 1 {
+		return fmt.Errorf("wrong version %d, expected >= 1", version)
+	}
+	t := reflect.TypeOf(sample)
+	if t == nil || t.Kind() != reflect.Pointer {
+		return fmt.Errorf("wrong sample %T, expected pointer", sample)
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	key := schemaKey{msgType: msgType, version: version}
+	if _, ok := r.schemas[key]; ok {
+		return fmt.Errorf("%s(v%d) already registered", msgType, version)
+	}
+	r.schemas[key] = schema{goType: t, validate: validate}
+	if old, ok := r.goTypes[t]; !ok || old.version < version {
+		r.goTypes[t] = key
+	}
+	return nil
+}
+
+// Encode wraps the message into the envelope and returns JSON
+func (r *Registry) Encode(msg Message) ([]byte, error) {
+	r.m.RLock()
+	key, ok := r.goTypes[reflect.TypeOf(msg)]
+	r.m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %T is not registered", msg)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal payload: %w", err)
+	}
+	return json.Marshal(Envelope{Type: key.msgType, Version: key.version, CreatedAt: time.Now().UTC(),
+		Producer: r.producer, Payload: payload})
+}
+
+// Decode decodes the envelope JSON into the registered type and validates it.
+// Unknown payload fields are not allowed. All errors are *DecodeError
+func (r *Registry) Decode(data []byte) (*Envelope, Message, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, nil, &DecodeError{Err: fmt.Errorf("no envelope: %w", err)}
+	}
+	if env.Type == "" || len(env.Payload) == 0 {
+		return nil, nil, &DecodeError{Type: env.Type, Version: env.Version, Err: fmt.Errorf("no envelope")}
+	}
+	r.m.RLock()
+	s, ok := r.schemas[schemaKey{msgType: env.Type, version: env.Version}]
+	r.m.RUnlock()
+	if !ok {
+		return nil, nil, &DecodeError{Type: env.Type, Version: env.Version, Err: ErrUnknownSchema}
+	}
+	res := reflect.New(s.goType.Elem()).Interface().(Message)
+	dec := json.NewDecoder(bytes.NewReader(env.Payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(res); err != nil {
+		return nil, nil, &DecodeError{Type: env.Type, Version: env.Version, Err: err}
+	}
+	if s.validate != nil {
+		if err := s.validate(res); err != nil {
+			return nil, nil, &DecodeError{Type: env.Type, Version: env.Version, Err: err}
+		}
+	}
+	return &env, res, nil
+}
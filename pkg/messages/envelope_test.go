@@ -0,0 +1,84 @@
+package messages
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry("test")
+	require.Nil(t, r.Register("queue", 1, &QueueMessage{}, nil))
+	require.Nil(t, r.Register("inform", 1, &InformMessage{}, func(msg Message) error {
+		if msg.(*InformMessage).Type == "" {
+			return errors.New("no type")
+		}
+		return nil
+	}))
+	return r
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := newTestRegistry(t)
+	assert.NotNil(t, r.Register("", 1, &QueueMessage{}, nil))
+	assert.NotNil(t, r.Register("queue", 0, &QueueMessage{}, nil))
+	assert.NotNil(t, r.Register("queue", 1, &QueueMessage{}, nil))
+	assert.NotNil(t, r.Register("queue", 2, nil, nil))
+	assert.Nil(t, r.Register("queue", 2, &QueueMessage{}, nil))
+}
+
+func TestRegistry_Encode(t *testing.T) {
+	r := newTestRegistry(t)
+	require.Nil(t, r.Register("queue", 2, &QueueMessage{}, nil))
+
+	data, err := r.Encode(&QueueMessage{ID: "1"})
+	require.Nil(t, err)
+	env, msg, err := r.Decode(data)
+	require.Nil(t, err)
+	assert.Equal(t, "queue", env.Type)
+	assert.Equal(t, 2, env.Version)
+	assert.Equal(t, "test", env.Producer)
+	assert.False(t, env.CreatedAt.IsZero())
+	assert.Equal(t, &QueueMessage{ID: "1"}, msg)
+
+	_, err = NewRegistry("").Encode(&QueueMessage{ID: "1"})
+	assert.NotNil(t, err)
+}
+
+func TestRegistry_Decode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Message
+		wantErr error
+	}{
+		{name: "OK", data: `{"type":"queue","version":1,"payload":{"id":"1"}}`, want: &QueueMessage{ID: "1"}},
+		{name: "Inform", data: `{"type":"inform","version":1,"payload":{"id":"1","type":"Started"}}`,
+			want: &InformMessage{QueueMessage: QueueMessage{ID: "1"}, Type: "Started"}},
+		{name: "Not valid", data: `{"type":"inform","version":1,"payload":{"id":"1"}}`},
+		{name: "Unknown version", data: `{"type":"queue","version":2,"payload":{"id":"1"}}`, wantErr: ErrUnknownSchema},
+		{name: "Unknown type", data: `{"type":"olia","version":1,"payload":{"id":"1"}}`, wantErr: ErrUnknownSchema},
+		{name: "Unknown field", data: `{"type":"queue","version":1,"payload":{"id":"1","olia":1}}`},
+		{name: "No envelope", data: `{"id":"1"}`},
+		{name: "Malformed", data: `{"type":`},
+	}
+	r := newTestRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := r.Decode([]byte(tt.data))
+			if tt.want == nil {
+				var de *DecodeError
+				assert.True(t, errors.As(err, &de))
+				if tt.wantErr != nil {
+					assert.True(t, errors.Is(err, tt.wantErr))
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -38,7 +38,7 @@ type InformMessage struct {
 
 // NewQueueMessageFromM copies message
 func NewQueueMessageFromM(m *QueueMessage) *QueueMessage {
-	return &QueueMessage{ID: m.ID, Tags: m.Tags}
+	return &QueueMessage{ID: m.ID, Tags: copyTags(m.Tags)}
 }
 
 // GetID returm message's ID
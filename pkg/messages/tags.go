@@ -0,0 +1,101 @@
+package messages
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Tagged is implemented by messages having tags
+type Tagged interface {
+	Tag(key string) (string, bool)
+}
+
+// Tag returns the value of the first tag with the key
+func (m *QueueMessage) Tag(key string) (string, bool) {
+	for _, t := range m.Tags {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetTag sets the tag value, adds a new tag if there is no tag with the key
+func (m *QueueMessage) SetTag(key, value string) {
+	for i := range m.Tags {
+		if m.Tags[i].Key == key {
+			m.Tags[i].Value = value
+			return
+		}
+	}
+	m.Tags = append(m.Tags, Tag{Key: key, Value: value})
+}
+
+// DeleteTag removes all tags with the key, returns false if there was none
+func (m *QueueMessage) DeleteTag(key string) bool {
+	var res []Tag
+	for _, t := range m.Tags {
+		if t.Key != key {
+			res = append(res, t)
+		}
+	}
+	if len(res) == len(m.Tags) {
+		return false
+	}
+	m.Tags = res
+	return true
+}
+
+// MergeTags sets all the tags, existing values are overwritten
+func (m *QueueMessage) MergeTags(tags []Tag) {
+	for _, t := range tags {
+		m.SetTag(t.Key, t.Value)
+	}
+}
+
+// TagInt returns the tag value as int, def is returned if there is no tag
+func (m *QueueMessage) TagInt(key string, def int) (int, error) {
+	v, ok := m.Tag(key)
+	if !ok {
+		return def, nil
+	}
+	res, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("wrong tag %s value %q: %w", key, v, err)
+	}
+	return res, nil
+}
+
+// TagBool returns the tag value as bool, def is returned if there is no tag
+func (m *QueueMessage) TagBool(key string, def bool) (bool, error) {
+	v, ok := m.Tag(key)
+	if !ok {
+		return def, nil
+	}
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("wrong tag %s value %q: %w", key, v, err)
+	}
+	return res, nil
+}
+
+// TagDuration returns the tag value as duration, e.g. "1m30s", def is returned if there is no tag
+func (m *QueueMessage) TagDuration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := m.Tag(key)
+	if !ok {
+		return def, nil
+	}
+	res, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("wrong tag %s value %q: %w", key, v, err)
+	}
+	return res, nil
+}
+
+func copyTags(tags []Tag) []Tag {
+	if tags == nil {
+		return nil
+	}
+	return append(make([]Tag, 0, len(tags)), tags...)
+}
@@ -0,0 +1,78 @@
+package messages
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueMessage_Tag(t *testing.T) {
+	m := &QueueMessage{Tags: []Tag{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}}
+	v, ok := m.Tag("b")
+	assert.True(t, ok)
+	assert.Equal(t, "2", v)
+	_, ok = m.Tag("c")
+	assert.False(t, ok)
+}
+
+func TestQueueMessage_SetTag(t *testing.T) {
+	m := &QueueMessage{}
+	m.SetTag("a", "1")
+	m.SetTag("b", "2")
+	m.SetTag("a", "3")
+	assert.Equal(t, []Tag{{Key: "a", Value: "3"}, {Key: "b", Value: "2"}}, m.Tags)
+}
+
+func TestQueueMessage_DeleteTag(t *testing.T) {
+	tags := []Tag{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "a", Value: "3"}}
+	m := &QueueMessage{Tags: tags}
+	assert.False(t, m.DeleteTag("c"))
+	assert.True(t, m.DeleteTag("a"))
+	assert.Equal(t, []Tag{{Key: "b", Value: "2"}}, m.Tags)
+	assert.Equal(t, "a", tags[0].Key)
+}
+
+func TestQueueMessage_MergeTags(t *testing.T) {
+	m := &QueueMessage{Tags: []Tag{{Key: "a", Value: "1"}}}
+	m.MergeTags([]Tag{{Key: "b", Value: "2"}, {Key: "a", Value: "3"}})
+	assert.Equal(t, []Tag{{Key: "a", Value: "3"}, {Key: "b", Value: "2"}}, m.Tags)
+}
+
+func TestQueueMessage_TypedTags(t *testing.T) {
+	m := &QueueMessage{Tags: []Tag{{Key: "i", Value: "10"}, {Key: "b", Value: "true"},
+		{Key: "d", Value: "1m30s"}, {Key: "x", Value: "olia"}}}
+	i, err := m.TagInt("i", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, i)
+	i, err = m.TagInt("none", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, i)
+	_, err = m.TagInt("x", 1)
+	assert.NotNil(t, err)
+
+	b, err := m.TagBool("b", false)
+	assert.Nil(t, err)
+	assert.True(t, b)
+	_, err = m.TagBool("x", false)
+	assert.NotNil(t, err)
+
+	d, err := m.TagDuration("d", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Second, d)
+	d, err = m.TagDuration("none", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, d)
+	_, err = m.TagDuration("x", time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestNewQueueMessageFromM(t *testing.T) {
+	m := &QueueMessage{ID: "1", Tags: []Tag{{Key: "a", Value: "1"}}}
+	got := NewQueueMessageFromM(m)
+	got.SetTag("a", "2")
+	got.SetTag("b", "3")
+	assert.Equal(t, "1", got.ID)
+	assert.Equal(t, []Tag{{Key: "a", Value: "1"}}, m.Tags)
+	assert.Nil(t, NewQueueMessageFromM(&QueueMessage{ID: "1"}).Tags)
+}
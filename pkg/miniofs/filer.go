@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/airenas/async-api/pkg/clean"
 	"github.com/airenas/go-app/pkg/goapp"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
@@ -86,17 +87,20 @@ func (fs *Filer) LoadFile(ctx context.Context, name string) (io.ReadSeekCloser,
 	return &fileWrap{f: res}, nil
 }
 
+// DeleteFile removes one file from s3/minio
+func (fs *Filer) DeleteFile(ctx context.Context, name string) error {
+	if err := fs.minioClient.RemoveObject(ctx, fs.bucket, name, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("can't remove %s: %w", name, err)
+	}
+	goapp.Log.Info().Str("file", name).Msg("removed")
+	return nil
+}
+
 // Clean removes all files from s3/minio starting by prefix
 func (fs *Filer) Clean(ctx context.Context, prefix string) error {
-	if prefix == "" {
-		return fmt.Errorf("no prefix")
-	}
-	_, err := uuid.Parse(prefix)
+	prefix, err := cleanPrefix(prefix)
 	if err != nil {
-		return fmt.Errorf("wrong ID")
-	}
-	if !strings.HasSuffix(prefix, "/") {
-		prefix = prefix + "/"
+		return err
 	}
 	goapp.Log.Info().Str("prefix", prefix).Msg("clean fs")
 	objectCh := fs.minioClient.ListObjects(ctx, fs.bucket, minio.ListObjectsOptions{
@@ -115,13 +119,62 @@ func (fs *Filer) Clean(ctx context.Context, prefix string) error {
 	return nil
 }
 
+// Plan returns files Clean would remove
+func (fs *Filer) Plan(ctx context.Context, prefix string) (*clean.Plan, error) {
+	prefix, err := cleanPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	res := &clean.Plan{}
+	for obj := range fs.minioClient.ListObjects(ctx, fs.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("can't list %s: %w", prefix, obj.Err)
+		}
+		res.Items = append(res.Items, obj.Key)
+	}
+	res.Count = int64(len(res.Items))
+	return res, nil
+}
+
+// String returns the cleaner's name for reports
+func (fs *Filer) String() string {
+	return "minio:" + fs.bucket
+}
+
+// cleanPrefix checks the ID and returns it as a directory prefix
+func cleanPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", fmt.Errorf("no prefix")
+	}
+	_, err := uuid.Parse(prefix)
+	if err != nil {
+		return "", fmt.Errorf("wrong ID")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return prefix, nil
+}
+
+// notExist wraps minio's missing object error with fs.ErrNotExist, minio returns it on the first read
+func notExist(err error) error {
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return err
+}
+
 type fileWrap struct {
 	f *minio.Object
 }
 
-// Read implements io.ReadSeekCloser
+// Read implements io.ReadSeekCloser, a missing object's error wraps fs.ErrNotExist
 func (fw *fileWrap) Read(p []byte) (n int, err error) {
-	return fw.f.Read(p)
+	n, err = fw.f.Read(p)
+	return n, notExist(err)
 }
 
 // Seek implements io.ReadSeekCloser
@@ -138,7 +191,7 @@ func (fw *fileWrap) Close() error {
 func (fw *fileWrap) Stat() (fs.FileInfo, error) {
 	st, err := fw.f.Stat()
 	if err != nil {
-		return nil, err
+		return nil, notExist(err)
 	}
 	return &statsWrap{oi: st}, nil
 }
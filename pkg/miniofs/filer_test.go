@@ -1,14 +1,41 @@
 package miniofs
 
 import (
+	"errors"
+	"io"
+	"io/fs"
 	"testing"
 
+	"github.com/airenas/async-api/pkg/clean"
+	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/assert"
 )
 
+var (
+	_ clean.Cleaner = (*Filer)(nil)
+	_ clean.Planner = (*Filer)(nil)
+)
+
 func TestValidate(t *testing.T) {
 	assert.Nil(t, validate(Options{URL: "olia", User: "olia", Bucket: "olia"}))
 	assert.NotNil(t, validate(Options{URL: "", User: "olia", Bucket: "olia"}))
 	assert.NotNil(t, validate(Options{URL: "olia", User: "", Bucket: "olia"}))
 	assert.NotNil(t, validate(Options{URL: "olia", User: "olia", Bucket: ""}))
 }
+
+func TestCleanPrefix(t *testing.T) {
+	got, err := cleanPrefix("0b9a5e3c-8b7b-4e8a-9a5a-6f3d1c2b4e5f")
+	assert.Nil(t, err)
+	assert.Equal(t, "0b9a5e3c-8b7b-4e8a-9a5a-6f3d1c2b4e5f/", got)
+	_, err = cleanPrefix("")
+	assert.NotNil(t, err)
+	_, err = cleanPrefix("olia")
+	assert.NotNil(t, err)
+}
+
+func TestNotExist(t *testing.T) {
+	assert.True(t, errors.Is(notExist(minio.ErrorResponse{Code: "NoSuchKey"}), fs.ErrNotExist))
+	assert.False(t, errors.Is(notExist(minio.ErrorResponse{Code: "AccessDenied"}), fs.ErrNotExist))
+	assert.Equal(t, io.EOF, notExist(io.EOF))
+	assert.Nil(t, notExist(nil))
+}
@@ -2,94 +2,130 @@ package mongo
 
 import (
 	"context"
+	"encoding/binary"
 	"time"
 
 	"github.com/airenas/go-app/pkg/goapp"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	mgo "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // CleanIDsProvider returns old IDs to remove from system
 type CleanIDsProvider struct {
 	sessionProvider *SessionProvider
-	expireDuration  time.Duration
-	table           string
+	opt             CleanIDsOptions
+}
+
+// CleanIDsOptions keeps CleanIDsProvider settings
+type CleanIDsOptions struct {
+	Table          string
+	ExpireDuration time.Duration
+	// TimeField is the record's time field compared with the expiration date, defaults to _id (ObjectID creation time).
+	// Add an index for other fields
+	TimeField string
+	// BatchSize is the cursor's batch size, defaults to 100
+	BatchSize int32
+	// MaxPerRun limits the number of records read by one GetExpired call, 0 - no limit
+	MaxPerRun int64
 }
 
 //NewCleanIDsProvider creates CleanIDsProvider instances
 func NewCleanIDsProvider(sessionProvider *SessionProvider, expireDuration time.Duration, table string) (*CleanIDsProvider, error) {
-	if expireDuration < time.Minute {
-		return nil, errors.Errorf("wrong expireDuration %s, expected >= 1m", expireDuration.String())
+	return NewCleanIDsProviderWithOptions(sessionProvider, CleanIDsOptions{Table: table, ExpireDuration: expireDuration})
+}
+
+//NewCleanIDsProviderWithOptions creates CleanIDsProvider instances with options
+func NewCleanIDsProviderWithOptions(sessionProvider *SessionProvider, opt CleanIDsOptions) (*CleanIDsProvider, error) {
+	if opt.ExpireDuration < time.Minute {
+		return nil, errors.Errorf("wrong expireDuration %s, expected >= 1m", opt.ExpireDuration.String())
 	}
-	if table == "" {
+	if opt.Table == "" {
 		return nil, errors.New("no table")
 	}
-	f := CleanIDsProvider{sessionProvider: sessionProvider, expireDuration: expireDuration, table: table}
+	if opt.BatchSize < 0 {
+		return nil, errors.Errorf("wrong batch size %d", opt.BatchSize)
+	}
+	if opt.BatchSize == 0 {
+		opt.BatchSize = 100
+	}
+	if opt.MaxPerRun < 0 {
+		return nil, errors.Errorf("wrong max per run %d", opt.MaxPerRun)
+	}
+	if opt.TimeField == "" {
+		opt.TimeField = "_id"
+	}
+	f := CleanIDsProvider{sessionProvider: sessionProvider, opt: opt}
 	return &f, nil
 }
 
-// GetExpired return expired IDs
-func (p *CleanIDsProvider) GetExpired() ([]string, error) {
-	expDate := time.Now().Add(-p.expireDuration)
-	goapp.Log.Info().Msgf("Getting old records, time < %s", expDate.String())
+// GetExpired return expired IDs, oldest first. The query is limited by 30s if ctx has no deadline
+func (p *CleanIDsProvider) GetExpired(ctx context.Context) ([]string, error) {
+	expDate := time.Now().Add(-p.opt.ExpireDuration)
+	goapp.Log.Info().Msgf("Getting old records, %s < %s", p.opt.TimeField, expDate.String())
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	ctx, cancel := withDefaultTimeout(ctx, time.Second*30)
 	defer cancel()
 
-	session, err := p.sessionProvider.NewSession()
+	session, err := p.sessionProvider.NewSession(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer session.EndSession(context.Background())
 
-	c := session.Client().Database(p.sessionProvider.store).Collection(p.table)
-	from := int64(0)
-	maxRecords := int64(10)
+	c := session.Client().Database(p.sessionProvider.store).Collection(p.opt.Table)
+	cursor, err := c.Find(ctx, expiredFilter(p.opt.TimeField, expDate), p.findOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select from "+p.opt.Table)
+	}
+	defer cursor.Close(context.Background())
+
 	res := make([]string, 0)
-	for {
-		cursor, err := c.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}).SetSkip(from).SetLimit(maxRecords))
-		if err != nil {
-			if err != mgo.ErrNoDocuments {
-				return nil, errors.Wrap(err, "can't select from "+p.table)
-			}
-			return res, nil
+	found := map[string]bool{}
+	for cursor.Next(ctx) {
+		var r bson.M
+		if err := cursor.Decode(&r); err != nil {
+			return nil, errors.Wrap(err, "can't decode")
 		}
-		var recs []bson.M
-		if err := cursor.All(ctx, &recs); err != nil {
-			return nil, errors.Wrap(err, "can't get data")
+		id, err := getID(r)
+		if err != nil {
+			return nil, err
 		}
-		goapp.Log.Debug().Msgf("Loaded %d records", len(recs))
-		for _, r := range recs {
-			if isOld(r, expDate) {
-				id, err := getID(r)
-				if err != nil {
-					return nil, err
-				}
-				res = append(res, id)
-			} else {
-				return res, nil
-			}
+		// several records may have the same ID
+		if !found[id] {
+			found[id] = true
+			res = append(res, id)
 		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, errors.Wrap(err, "can't get data")
+	}
+	goapp.Log.Debug().Msgf("Loaded %d IDs", len(res))
+	return res, nil
+}
 
-		from = from + maxRecords
-		if from > int64(len(res)) {
-			return res, nil
-		}
-		// do futher selection
+func (p *CleanIDsProvider) findOptions() *options.FindOptions {
+	res := options.Find().SetSort(bson.M{p.opt.TimeField: 1}).SetProjection(bson.M{"ID": 1, "_id": 0}).
+		SetBatchSize(p.opt.BatchSize)
+	if p.opt.MaxPerRun > 0 {
+		res.SetLimit(p.opt.MaxPerRun)
 	}
+	return res
 }
 
-func isOld(m bson.M, expireDate time.Time) bool {
-	id, ok := m["_id"].(primitive.ObjectID)
-	if !ok {
-		goapp.Log.Warn().Msg("_id not found in record")
-		return false
+func expiredFilter(timeField string, expireDate time.Time) bson.M {
+	if timeField == "_id" {
+		return bson.M{"_id": bson.M{"$lt": objectIDFromTime(expireDate)}}
 	}
-	goapp.Log.Debug().Msgf("_id time %s", id.Timestamp().String())
-	return id.Timestamp().Before(expireDate)
+	return bson.M{timeField: bson.M{"$lt": expireDate}}
+}
+
+// objectIDFromTime returns the smallest ObjectID of the time, other bytes are zeros
+func objectIDFromTime(t time.Time) primitive.ObjectID {
+	var res primitive.ObjectID
+	binary.BigEndian.PutUint32(res[0:4], uint32(t.Unix()))
+	return res
 }
 
 func getID(m bson.M) (string, error) {
@@ -34,31 +34,43 @@ func Test_getID(t *testing.T) {
 	}
 }
 
-func Test_isOld(t *testing.T) {
-	type args struct {
-		m          bson.M
-		expireDate time.Time
-	}
-	tests := []struct {
-		name string
-		args args
-		want bool
-	}{
-		{name: "expired", args: args{m: bson.M{"ID": "olia"}, expireDate: time.Now().Add(time.Minute)}, want: false},
-		{name: "expired", args: args{m: bson.M{"_id": primitive.NewObjectIDFromTimestamp(time.Now())},
-			expireDate: time.Now().Add(time.Minute)}, want: true},
-		{name: "expired", args: args{m: bson.M{"_id": primitive.NewObjectIDFromTimestamp(time.Now().Add(-time.Hour))},
-			expireDate: time.Now().Add(-2 * time.Hour)}, want: false},
-		{name: "expired", args: args{m: bson.M{"_id": primitive.NewObjectIDFromTimestamp(time.Now().Add(-3 * time.Hour))},
-			expireDate: time.Now().Add(-2 * time.Hour)}, want: true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := isOld(tt.args.m, tt.args.expireDate); got != tt.want {
-				t.Errorf("isOld() = %v, want %v", got, tt.want)
-			}
-		})
-	}
+func Test_expiredFilter(t *testing.T) {
+	at := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, bson.M{"_id": bson.M{"$lt": objectIDFromTime(at)}}, expiredFilter("_id", at))
+	assert.Equal(t, bson.M{"updated": bson.M{"$lt": at}}, expiredFilter("updated", at))
+}
+
+func Test_objectIDFromTime(t *testing.T) {
+	at := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := objectIDFromTime(at)
+	assert.Equal(t, at, got.Timestamp().UTC())
+	assert.Equal(t, "61d11625"+"0000000000000000", got.Hex())
+	assert.True(t, got.Hex() < primitive.NewObjectIDFromTimestamp(at).Hex())
+}
+
+func TestCleanIDsProvider_findOptions(t *testing.T) {
+	p, err := NewCleanIDsProviderWithOptions(nil, CleanIDsOptions{Table: "t", ExpireDuration: time.Hour})
+	assert.Nil(t, err)
+	got := p.findOptions()
+	assert.Equal(t, bson.M{"_id": 1}, got.Sort)
+	assert.Equal(t, bson.M{"ID": 1, "_id": 0}, got.Projection)
+	assert.Equal(t, int32(100), *got.BatchSize)
+	assert.Nil(t, got.Limit)
+
+	p, err = NewCleanIDsProviderWithOptions(nil, CleanIDsOptions{Table: "t", ExpireDuration: time.Hour,
+		TimeField: "updated", BatchSize: 10, MaxPerRun: 1000})
+	assert.Nil(t, err)
+	got = p.findOptions()
+	assert.Equal(t, bson.M{"updated": 1}, got.Sort)
+	assert.Equal(t, int32(10), *got.BatchSize)
+	assert.Equal(t, int64(1000), *got.Limit)
+}
+
+func TestNewCleanIDsProviderWithOptions_Fail(t *testing.T) {
+	_, err := NewCleanIDsProviderWithOptions(nil, CleanIDsOptions{Table: "t", ExpireDuration: time.Hour, BatchSize: -1})
+	assert.NotNil(t, err)
+	_, err = NewCleanIDsProviderWithOptions(nil, CleanIDsOptions{Table: "t", ExpireDuration: time.Hour, MaxPerRun: -1})
+	assert.NotNil(t, err)
 }
 
 func TestNewCleanIDsProvider(t *testing.T) {
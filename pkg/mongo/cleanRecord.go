@@ -1,6 +1,9 @@
 package mongo
 
 import (
+	"context"
+
+	"github.com/airenas/async-api/pkg/clean"
 	"github.com/airenas/go-app/pkg/goapp"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -26,10 +29,10 @@ func NewCleanRecord(sessionProvider *SessionProvider, table string) (*CleanRecor
 }
 
 // Clean deletes record from table by ID
-func (fs *CleanRecord) Clean(ID string) error {
+func (fs *CleanRecord) Clean(ctx context.Context, ID string) error {
 	goapp.Log.Info().Msgf("Cleaning record for for %s[ID=%s]", fs.table, ID)
 
-	c, ctx, cancel, err := NewCollection(fs.sessionProvider, fs.table)
+	c, ctx, cancel, err := NewCollection(ctx, fs.sessionProvider, fs.table)
 	if err != nil {
 		return err
 	}
@@ -42,3 +45,23 @@ func (fs *CleanRecord) Clean(ID string) error {
 	goapp.Log.Info().Msgf("Deleted %d", info.DeletedCount)
 	return nil
 }
+
+// Plan returns the number of records Clean would delete
+func (fs *CleanRecord) Plan(ctx context.Context, ID string) (*clean.Plan, error) {
+	c, ctx, cancel, err := NewCollection(ctx, fs.sessionProvider, fs.table)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	n, err := c.CountDocuments(ctx, bson.M{"ID": ID})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't count")
+	}
+	return &clean.Plan{Count: n}, nil
+}
+
+// String returns the cleaner's name for reports
+func (fs *CleanRecord) String() string {
+	return "mongo:" + fs.table
+}
@@ -3,9 +3,12 @@ package mongo
 import (
 	"testing"
 
+	"github.com/airenas/async-api/pkg/clean"
 	"github.com/stretchr/testify/assert"
 )
 
+var _ clean.Planner = (*CleanRecord)(nil)
+
 func TestNewCleanRecord(t *testing.T) {
 	type args struct {
 		sessionProvider *SessionProvider
@@ -34,3 +37,11 @@ func TestNewCleanRecord(t *testing.T) {
 		})
 	}
 }
+
+func TestCleanRecord_String(t *testing.T) {
+	sp, err := NewSessionProvider("mongodb://localhost:27017", nil, "db")
+	assert.Nil(t, err)
+	cr, err := NewCleanRecord(sp, "requests")
+	assert.Nil(t, err)
+	assert.Equal(t, "mongo:requests", cr.String())
+}
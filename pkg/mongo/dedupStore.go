@@ -0,0 +1,115 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/airenas/async-api/pkg/dedup"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DedupStore keeps processed message keys in mongo table, implements dedup.Store.
+// The key is the record's _id, expired records are removed by the TTL index, see DedupIndexes
+type DedupStore struct {
+	sessionProvider *SessionProvider
+	table           string
+	opt             dedup.Options
+}
+
+type dedupRecord struct {
+	Key       string    `bson:"_id"`
+	Done      bool      `bson:"done"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// NewDedupStore creates DedupStore instance, add DedupIndexes to the SessionProvider
+func NewDedupStore(sessionProvider *SessionProvider, table string, opt dedup.Options) (*DedupStore, error) {
+	if sessionProvider == nil {
+		return nil, errors.New("no session provider")
+	}
+	if table == "" {
+		return nil, errors.New("no table")
+	}
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+	return &DedupStore{sessionProvider: sessionProvider, table: table, opt: opt}, nil
+}
+
+// DedupIndexes returns indexes for the dedup table, mongo removes records at expiresAt
+func DedupIndexes(table string) []IndexData {
+	return []IndexData{NewTTLIndexData(table, "expiresAt", 0)}
+}
+
+// Begin marks the key as in progress, a record with expired lease is taken over
+func (ds *DedupStore) Begin(ctx context.Context, key string) (dedup.State, error) {
+	c, ctx, cancel, err := NewCollection(ctx, ds.sessionProvider, ds.table)
+	if err != nil {
+		return dedup.New, err
+	}
+	defer cancel()
+
+	now := time.Now()
+	_, err = c.InsertOne(ctx, dedupRecord{Key: key, ExpiresAt: now.Add(ds.opt.Lease)})
+	if err == nil {
+		return dedup.New, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return dedup.New, errors.Wrap(err, "can't insert dedup record")
+	}
+	// TTL index removes records once a minute, so the record may be expired
+	err = c.FindOneAndUpdate(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"done": false, "expiresAt": now.Add(ds.opt.Lease)}}).Err()
+	if err == nil {
+		return dedup.New, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return dedup.New, errors.Wrap(err, "can't take over dedup record")
+	}
+	var res dedupRecord
+	err = c.FindOne(ctx, bson.M{"_id": key}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		// removed in between, let the message be retried
+		return dedup.InProgress, nil
+	}
+	if err != nil {
+		return dedup.New, errors.Wrap(err, "can't load dedup record")
+	}
+	if res.Done {
+		return dedup.Done, nil
+	}
+	return dedup.InProgress, nil
+}
+
+// Complete marks the key as done for the TTL
+func (ds *DedupStore) Complete(ctx context.Context, key string) error {
+	c, ctx, cancel, err := NewCollection(ctx, ds.sessionProvider, ds.table)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = c.ReplaceOne(ctx, bson.M{"_id": key},
+		dedupRecord{Key: key, Done: true, ExpiresAt: time.Now().Add(ds.opt.TTL)}, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "can't update dedup record")
+	}
+	return nil
+}
+
+// Release removes the in progress record
+func (ds *DedupStore) Release(ctx context.Context, key string) error {
+	c, ctx, cancel, err := NewCollection(ctx, ds.sessionProvider, ds.table)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if _, err := c.DeleteOne(ctx, bson.M{"_id": key, "done": false}); err != nil {
+		return errors.Wrap(err, "can't delete dedup record")
+	}
+	return nil
+}
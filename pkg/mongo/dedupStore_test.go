@@ -0,0 +1,46 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/pkg/dedup"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ dedup.Store = (*DedupStore)(nil)
+
+func TestNewDedupStore(t *testing.T) {
+	sp, err := NewSessionProvider("mongodb://localhost:27017", nil, "db")
+	assert.Nil(t, err)
+	tests := []struct {
+		name    string
+		sp      *SessionProvider
+		table   string
+		opt     dedup.Options
+		wantErr bool
+	}{
+		{name: "OK", sp: sp, table: "dedup"},
+		{name: "No session provider", table: "dedup", wantErr: true},
+		{name: "No table", sp: sp, wantErr: true},
+		{name: "Wrong TTL", sp: sp, table: "dedup", opt: dedup.Options{TTL: -time.Second}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDedupStore(tt.sp, tt.table, tt.opt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDedupStore() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, 24*time.Hour, got.opt.TTL)
+			}
+		})
+	}
+}
+
+func TestDedupIndexes(t *testing.T) {
+	got := DedupIndexes("dedup")
+	assert.Equal(t, []string{"expiresAt"}, got[0].Fields)
+	assert.Equal(t, time.Duration(0), *got[0].ExpireAfter)
+}
@@ -0,0 +1,186 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexSpec is the existing index description returned by mongo
+type indexSpec struct {
+	Name                    string      `bson:"name"`
+	Key                     bson.D      `bson:"key"`
+	Unique                  bool        `bson:"unique"`
+	Sparse                  bool        `bson:"sparse"`
+	ExpireAfterSeconds      interface{} `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw    `bson:"partialFilterExpression"`
+}
+
+// idIndexName is the name of mongo's default _id index
+const idIndexName = "_id_"
+
+// ensureIndex creates the index, existing index with the same name or keys is dropped if its options differ
+func ensureIndex(ctx context.Context, c *mongo.Collection, data IndexData) error {
+	model, err := indexModel(data)
+	if err != nil {
+		return err
+	}
+	cursor, err := c.Indexes().List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't list indexes")
+	}
+	var existing []indexSpec
+	if err := cursor.All(ctx, &existing); err != nil {
+		return errors.Wrap(err, "can't read indexes")
+	}
+	name := indexName(data)
+	for _, e := range existing {
+		// mongo's _id index can't be changed
+		if e.Name == idIndexName {
+			continue
+		}
+		if e.Name != name && !sameKeys(e.Key, indexKeys(data.Fields)) {
+			continue
+		}
+		if e.Name == name && sameIndex(e, data) {
+			return nil
+		}
+		goapp.Log.Warn().Msgf("Dropping index %s.%s, options changed", data.Table, e.Name)
+		if _, err := c.Indexes().DropOne(ctx, e.Name); err != nil {
+			return errors.Wrapf(err, "can't drop index %s", e.Name)
+		}
+	}
+	goapp.Log.Info().Msgf("Creating index %s.%s", data.Table, name)
+	_, err = c.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+func indexModel(data IndexData) (mongo.IndexModel, error) {
+	if len(data.Fields) == 0 {
+		return mongo.IndexModel{}, errors.New("no fields")
+	}
+	for _, f := range data.Fields {
+		if strings.TrimPrefix(f, "-") == "" {
+			return mongo.IndexModel{}, errors.New("empty field")
+		}
+	}
+	if len(data.Fields) == 1 && strings.TrimPrefix(data.Fields[0], "-") == "_id" {
+		return mongo.IndexModel{}, errors.New("_id is indexed by mongo")
+	}
+	if data.ExpireAfter != nil {
+		if *data.ExpireAfter < 0 {
+			return mongo.IndexModel{}, errors.Errorf("wrong expire after %v", *data.ExpireAfter)
+		}
+		if len(data.Fields) > 1 {
+			return mongo.IndexModel{}, errors.New("TTL index must have one field")
+		}
+		if strings.TrimPrefix(data.Fields[0], "-") == "_id" {
+			return mongo.IndexModel{}, errors.New("TTL index can't be on _id")
+		}
+	}
+	opt := options.Index().SetName(indexName(data)).SetUnique(data.Unique).SetBackground(true)
+	if data.ExpireAfter != nil {
+		opt.SetExpireAfterSeconds(expireSeconds(*data.ExpireAfter))
+	}
+	if data.PartialFilter != nil {
+		opt.SetPartialFilterExpression(data.PartialFilter)
+	} else {
+		opt.SetSparse(true)
+	}
+	return mongo.IndexModel{Keys: indexKeys(data.Fields), Options: opt}, nil
+}
+
+func indexKeys(fields []string) bson.D {
+	res := bson.D{}
+	for _, f := range fields {
+		if strings.HasPrefix(f, "-") {
+			res = append(res, bson.E{Key: strings.TrimPrefix(f, "-"), Value: int32(-1)})
+		} else {
+			res = append(res, bson.E{Key: f, Value: int32(1)})
+		}
+	}
+	return res
+}
+
+// indexName returns the name set in data or mongo's default name
+func indexName(data IndexData) string {
+	if data.Name != "" {
+		return data.Name
+	}
+	parts := make([]string, 0, len(data.Fields))
+	for _, k := range indexKeys(data.Fields) {
+		parts = append(parts, fmt.Sprintf("%s_%d", k.Key, k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// expireSeconds rounds positive durations up to 1s, so only 0 expires records at the field's time
+func expireSeconds(d time.Duration) int32 {
+	res := int32(d / time.Second)
+	if res < 1 && d > 0 {
+		return 1
+	}
+	return res
+}
+
+func sameIndex(e indexSpec, data IndexData) bool {
+	if !sameKeys(e.Key, indexKeys(data.Fields)) || e.Unique != data.Unique {
+		return false
+	}
+	exp, ok := toInt64(e.ExpireAfterSeconds)
+	if data.ExpireAfter != nil {
+		if !ok || exp != int64(expireSeconds(*data.ExpireAfter)) {
+			return false
+		}
+	} else if ok {
+		return false
+	}
+	if data.PartialFilter == nil {
+		return e.Sparse && len(e.PartialFilterExpression) == 0
+	}
+	want, err := bson.Marshal(data.PartialFilter)
+	if err != nil {
+		return false
+	}
+	return !e.Sparse && bytes.Equal(want, e.PartialFilterExpression)
+}
+
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key {
+			return false
+		}
+		av, aOK := toInt64(a[i].Value)
+		bv, bOK := toInt64(b[i].Value)
+		if !aOK || !bOK || av != bv {
+			return false
+		}
+	}
+	return true
+}
+
+// toInt64 converts mongo number, it may be returned as int32, int64 or double
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
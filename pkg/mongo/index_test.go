@@ -0,0 +1,98 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_indexName(t *testing.T) {
+	assert.Equal(t, "ID_1", indexName(NewIndexData("t", "ID", true)))
+	assert.Equal(t, "ID_1_updated_-1", indexName(IndexData{Fields: []string{"ID", "-updated"}}))
+	assert.Equal(t, "olia", indexName(IndexData{Fields: []string{"ID"}, Name: "olia"}))
+}
+
+func Test_indexModel(t *testing.T) {
+	hour := time.Hour
+	tests := []struct {
+		name    string
+		data    IndexData
+		wantErr bool
+	}{
+		{name: "OK", data: NewIndexData("t", "ID", true)},
+		{name: "Compound", data: IndexData{Fields: []string{"ID", "-key"}}},
+		{name: "TTL", data: NewTTLIndexData("t", "updated", time.Hour)},
+		{name: "TTL at date", data: NewTTLIndexData("t", "expiresAt", 0)},
+		{name: "Compound with _id", data: IndexData{Fields: []string{"sent", "_id"}}},
+		{name: "Partial", data: IndexData{Fields: []string{"ID"}, PartialFilter: bson.D{{Key: "status", Value: 1}}}},
+		{name: "No fields", data: IndexData{}, wantErr: true},
+		{name: "Empty field", data: IndexData{Fields: []string{"-"}}, wantErr: true},
+		{name: "Compound TTL", data: IndexData{Fields: []string{"ID", "updated"}, ExpireAfter: &hour}, wantErr: true},
+		{name: "Wrong TTL", data: NewTTLIndexData("t", "updated", -time.Hour), wantErr: true},
+		{name: "_id", data: NewIndexData("t", "_id", true), wantErr: true},
+		{name: "Desc _id", data: IndexData{Fields: []string{"-_id"}}, wantErr: true},
+		{name: "TTL _id", data: NewTTLIndexData("t", "_id", time.Hour), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := indexModel(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("indexModel() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, indexKeys(tt.data.Fields), got.Keys)
+				assert.Equal(t, indexName(tt.data), *got.Options.Name)
+				assert.Equal(t, tt.data.PartialFilter == nil, got.Options.Sparse != nil)
+				assert.Equal(t, tt.data.ExpireAfter != nil, got.Options.ExpireAfterSeconds != nil)
+			}
+		})
+	}
+}
+
+func Test_sameIndex(t *testing.T) {
+	filter := bson.D{{Key: "status", Value: 1}}
+	raw, _ := bson.Marshal(filter)
+	tests := []struct {
+		name string
+		spec indexSpec
+		data IndexData
+		want bool
+	}{
+		{name: "Same", spec: indexSpec{Key: bson.D{{Key: "ID", Value: int32(1)}}, Unique: true, Sparse: true},
+			data: NewIndexData("t", "ID", true), want: true},
+		{name: "Double direction", spec: indexSpec{Key: bson.D{{Key: "ID", Value: 1.0}}, Sparse: true},
+			data: NewIndexData("t", "ID", false), want: true},
+		{name: "Unique differs", spec: indexSpec{Key: bson.D{{Key: "ID", Value: int32(1)}}, Sparse: true},
+			data: NewIndexData("t", "ID", true)},
+		{name: "Direction differs", spec: indexSpec{Key: bson.D{{Key: "ID", Value: int32(-1)}}, Sparse: true},
+			data: NewIndexData("t", "ID", false)},
+		{name: "TTL same", spec: indexSpec{Key: bson.D{{Key: "u", Value: int32(1)}}, Sparse: true, ExpireAfterSeconds: int32(3600)},
+			data: NewTTLIndexData("t", "u", time.Hour), want: true},
+		{name: "TTL differs", spec: indexSpec{Key: bson.D{{Key: "u", Value: int32(1)}}, Sparse: true, ExpireAfterSeconds: int64(60)},
+			data: NewTTLIndexData("t", "u", time.Hour)},
+		{name: "TTL at date same", spec: indexSpec{Key: bson.D{{Key: "u", Value: int32(1)}}, Sparse: true, ExpireAfterSeconds: int32(0)},
+			data: NewTTLIndexData("t", "u", 0), want: true},
+		{name: "TTL at date added", spec: indexSpec{Key: bson.D{{Key: "u", Value: int32(1)}}, Sparse: true},
+			data: NewTTLIndexData("t", "u", 0)},
+		{name: "TTL removed", spec: indexSpec{Key: bson.D{{Key: "u", Value: int32(1)}}, Sparse: true, ExpireAfterSeconds: int32(60)},
+			data: NewIndexData("t", "u", false)},
+		{name: "Partial same", spec: indexSpec{Key: bson.D{{Key: "ID", Value: int32(1)}}, PartialFilterExpression: raw},
+			data: IndexData{Fields: []string{"ID"}, PartialFilter: filter}, want: true},
+		{name: "Partial added", spec: indexSpec{Key: bson.D{{Key: "ID", Value: int32(1)}}, Sparse: true},
+			data: IndexData{Fields: []string{"ID"}, PartialFilter: filter}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sameIndex(tt.spec, tt.data))
+		})
+	}
+}
+
+func Test_expireSeconds(t *testing.T) {
+	assert.Equal(t, int32(0), expireSeconds(0))
+	assert.Equal(t, int32(1), expireSeconds(time.Millisecond))
+	assert.Equal(t, int32(3600), expireSeconds(time.Hour))
+}
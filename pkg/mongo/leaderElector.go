@@ -0,0 +1,59 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const leaderKey = "leader"
+
+// LeaderElector elects the job's leader using Locker leases, implements clean.Elector.
+// The job is the lock record's ID, instances are identified by the Locker's owner.
+// If the leader dies, its lease expires and other instance takes over the job
+type LeaderElector struct {
+	locker *Locker
+}
+
+// NewLeaderElector creates LeaderElector instance, the leadership's lease is the locker's lease
+func NewLeaderElector(locker *Locker) (*LeaderElector, error) {
+	if locker == nil {
+		return nil, errors.New("no locker")
+	}
+	return &LeaderElector{locker: locker}, nil
+}
+
+// Acquire renews the owner's lease or takes the free or expired one
+func (le *LeaderElector) Acquire(ctx context.Context, job string) (bool, error) {
+	err := le.locker.Renew(ctx, job, leaderKey)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, ErrNotOwner) {
+		return false, err
+	}
+	err = le.locker.Lock(ctx, job, leaderKey)
+	if errors.Is(err, ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lease returns the locker's lease
+func (le *LeaderElector) Lease() time.Duration {
+	return le.locker.Lease()
+}
+
+// Release frees the lease if the owner holds it
+func (le *LeaderElector) Release(ctx context.Context, job string) error {
+	free := lockStatusFree
+	err := le.locker.UnLock(ctx, job, leaderKey, &free)
+	if errors.Is(err, ErrNotOwner) {
+		return nil
+	}
+	return err
+}
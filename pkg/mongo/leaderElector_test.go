@@ -0,0 +1,26 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/pkg/clean"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ clean.Elector = (*LeaderElector)(nil)
+
+func TestNewLeaderElector(t *testing.T) {
+	sp, err := NewSessionProvider("mongodb://localhost:27017", nil, "db")
+	assert.Nil(t, err)
+	locker, err := NewLocker(sp, "lock")
+	assert.Nil(t, err)
+
+	got, err := NewLeaderElector(locker)
+	assert.Nil(t, err)
+	assert.NotNil(t, got)
+	assert.Equal(t, 5*time.Minute, got.Lease())
+
+	_, err = NewLeaderElector(nil)
+	assert.NotNil(t, err)
+}
@@ -1,32 +1,96 @@
 package mongo
 
 import (
+	"context"
+	"os"
+	"time"
+
 	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Locker acquires lock in db
+var (
+	//ErrLocked is returned when the record is locked by other owner and the lease is not expired
+	ErrLocked = errors.New("locked")
+	//ErrNotOwner is returned when the lock is not held by the owner, e.g. the lease expired and was taken over
+	ErrNotOwner = errors.New("lock is not owned")
+)
+
+const (
+	lockStatusFree   = 0
+	lockStatusLocked = 1
+)
+
+// Locker acquires lock in db.
+// The lock is a lease: it expires if not renewed, so a crashed owner does not keep it forever.
+// Records keep the last change time in the updated field, use NewTTLIndexData(table, "updated", ttl) to expire them
 type Locker struct {
 	SessionProvider *SessionProvider
 	table           string
+	owner           string
+	lease           time.Duration
+}
+
+//LockerOptions keeps Locker settings
+type LockerOptions struct {
+	Table string
+	// Owner identifies the lock holder, defaults to <hostname>-<random>
+	Owner string
+	// Lease is the lock expiration time, defaults to 5m. Use Renew or KeepAlive for longer jobs
+	Lease time.Duration
 }
 
 //NewLocker creates Locker instance
 func NewLocker(sessionProvider *SessionProvider, table string) (*Locker, error) {
-	if table == "" {
+	return NewLockerWithOptions(sessionProvider, LockerOptions{Table: table})
+}
+
+//NewLockerWithOptions creates Locker instance with options
+func NewLockerWithOptions(sessionProvider *SessionProvider, opt LockerOptions) (*Locker, error) {
+	if opt.Table == "" {
 		return nil, errors.New("no table")
 	}
-	f := Locker{SessionProvider: sessionProvider, table: table}
+	if opt.Lease < 0 {
+		return nil, errors.Errorf("wrong lease %v", opt.Lease)
+	}
+	if opt.Lease == 0 {
+		opt.Lease = 5 * time.Minute
+	}
+	if opt.Owner == "" {
+		opt.Owner = defaultOwner()
+	}
+	f := Locker{SessionProvider: sessionProvider, table: opt.Table, owner: opt.Owner, lease: opt.Lease}
 	return &f, nil
 }
 
-//Lock locks record for sending email
-func (ss *Locker) Lock(id string, lockKey string) error {
-	goapp.Log.Info().Msgf("Locking %s: %s", id, lockKey)
+func defaultOwner() string {
+	res, _ := os.Hostname()
+	if res == "" {
+		res = "unknown"
+	}
+	return res + "-" + uuid.NewString()[:8]
+}
+
+//Owner returns the lock owner ID
+func (ss *Locker) Owner() string {
+	return ss.owner
+}
 
-	c, ctx, cancel, err := NewCollection(ss.SessionProvider, ss.table)
+//Lease returns the lock expiration time
+func (ss *Locker) Lease() time.Duration {
+	return ss.lease
+}
+
+//Lock locks record for the owner. A free record or a record with expired lease is taken over.
+//ErrLocked is returned if the record is locked by someone else
+func (ss *Locker) Lock(ctx context.Context, id string, lockKey string) error {
+	goapp.Log.Debug().Msgf("Locking %s: %s", id, lockKey)
+
+	c, ctx, cancel, err := NewCollection(ctx, ss.SessionProvider, ss.table)
 	if err != nil {
 		return err
 	}
@@ -35,28 +99,123 @@ func (ss *Locker) Lock(id string, lockKey string) error {
 	// make sure we have the record
 	err = SkipNoDocErr(c.FindOneAndUpdate(ctx, bson.M{
 		"$and": []bson.M{{"ID": Sanitize(id)}, {"key": lockKey}}},
-		bson.M{"$setOnInsert": bson.M{"status": 0}},
+		bson.M{"$setOnInsert": bson.M{"status": lockStatusFree, "updated": time.Now()}},
 		options.FindOneAndUpdate().SetUpsert(true)).Err())
 	if err != nil {
-		return errors.Wrap(err, "can't insert email lock table")
+		return errors.Wrap(err, "can't insert lock record")
+	}
+
+	// locks made before leases were introduced may have no updated time, start their lease now
+	_, err = c.UpdateOne(ctx, bson.M{"$and": []bson.M{{"ID": Sanitize(id)}, {"key": lockKey}, legacyNoTimeFilter()}},
+		bson.M{"$set": bson.M{"updated": time.Now()}})
+	if err != nil {
+		return errors.Wrap(err, "can't update legacy lock record")
 	}
 
-	return c.FindOneAndUpdate(ctx, bson.M{
-		"$and": []bson.M{{"ID": Sanitize(id)}, {"key": lockKey}, {"status": 0}}},
-		bson.M{"$set": bson.M{"status": 1}}, options.FindOneAndUpdate().SetUpsert(false)).Err()
+	now := time.Now()
+	err = c.FindOneAndUpdate(ctx, bson.M{
+		"$and": []bson.M{{"ID": Sanitize(id)}, {"key": lockKey}, lockableFilter(now, ss.lease)}},
+		bson.M{"$set": bson.M{"status": lockStatusLocked, "owner": ss.owner, "expiresAt": now.Add(ss.lease),
+			"updated": now}},
+		options.FindOneAndUpdate().SetUpsert(false)).Err()
+	if err == mongo.ErrNoDocuments {
+		return errors.Wrapf(ErrLocked, "%s: %s", id, lockKey)
+	}
+	return err
+}
+
+// lockableFilter selects free records and records with expired lease.
+// Locks made before leases were introduced have no expiresAt, their lease is counted from the updated time
+func lockableFilter(now time.Time, lease time.Duration) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"status": lockStatusFree},
+		{"status": lockStatusLocked, "expiresAt": bson.M{"$lt": now}},
+		{"status": lockStatusLocked, "expiresAt": bson.M{"$exists": false}, "updated": bson.M{"$lt": now.Add(-lease)}},
+	}}
+}
+
+// legacyNoTimeFilter selects locks made before leases were introduced and not seen by Lock yet
+func legacyNoTimeFilter() bson.M {
+	return bson.M{"status": lockStatusLocked, "expiresAt": bson.M{"$exists": false}, "updated": bson.M{"$exists": false}}
+}
+
+//Renew extends the owner's lease, ErrNotOwner is returned if the lock was lost
+func (ss *Locker) Renew(ctx context.Context, id string, lockKey string) error {
+	c, ctx, cancel, err := NewCollection(ctx, ss.SessionProvider, ss.table)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	now := time.Now()
+	err = c.FindOneAndUpdate(ctx, ss.ownedFilter(id, lockKey),
+		bson.M{"$set": bson.M{"expiresAt": now.Add(ss.lease), "updated": now}},
+		options.FindOneAndUpdate().SetUpsert(false)).Err()
+	if err == mongo.ErrNoDocuments {
+		return errors.Wrapf(ErrNotOwner, "%s: %s", id, lockKey)
+	}
+	return err
+}
+
+//KeepAlive renews the lease every lease/3 until ctx is done.
+//The returned channel is closed when renewing stops, check ctx.Err() to know if the lock was lost
+func (ss *Locker) KeepAlive(ctx context.Context, id string, lockKey string) <-chan struct{} {
+	res := make(chan struct{})
+	go func() {
+		defer close(res)
+		ticker := time.NewTicker(renewInterval(ss.lease))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := ss.Renew(ctx, id, lockKey)
+				if errors.Is(err, ErrNotOwner) {
+					goapp.Log.Error().Err(err).Msg("lock lost")
+					return
+				}
+				if err != nil {
+					goapp.Log.Warn().Err(err).Msg("can't renew lock")
+				}
+			}
+		}
+	}()
+	return res
 }
 
-//UnLock marks record with specific value
-func (ss *Locker) UnLock(id string, lockKey string, value *int) error {
-	goapp.Log.Info().Msgf("Unlocking table %s: %s", id, lockKey)
+// renewInterval returns the interval to renew the lease before it expires, at least 1ns
+func renewInterval(lease time.Duration) time.Duration {
+	if lease < 3 {
+		return 1
+	}
+	return lease / 3
+}
 
-	c, ctx, cancel, err := NewCollection(ss.SessionProvider, ss.table)
+//UnLock marks owner's record with specific value, e.g. 0 to release the lock for retry.
+//ErrNotOwner is returned if the lock is not held by the owner
+func (ss *Locker) UnLock(ctx context.Context, id string, lockKey string, value *int) error {
+	goapp.Log.Debug().Msgf("Unlocking table %s: %s", id, lockKey)
+	if value == nil {
+		return errors.New("no value")
+	}
+
+	c, ctx, cancel, err := NewCollection(ctx, ss.SessionProvider, ss.table)
 	if err != nil {
 		return err
 	}
 	defer cancel()
 
-	return c.FindOneAndUpdate(ctx, bson.M{
-		"$and": []bson.M{{"ID": Sanitize(id)}, {"key": lockKey}, {"status": 1}}},
-		bson.M{"$set": bson.M{"status": *value}}, options.FindOneAndUpdate().SetUpsert(false)).Err()
+	err = c.FindOneAndUpdate(ctx, ss.ownedFilter(id, lockKey),
+		bson.M{"$set": bson.M{"status": *value, "updated": time.Now()}, "$unset": bson.M{"owner": "", "expiresAt": ""}},
+		options.FindOneAndUpdate().SetUpsert(false)).Err()
+	if err == mongo.ErrNoDocuments {
+		return errors.Wrapf(ErrNotOwner, "%s: %s", id, lockKey)
+	}
+	return err
+}
+
+func (ss *Locker) ownedFilter(id string, lockKey string) bson.M {
+	return bson.M{"$and": []bson.M{{"ID": Sanitize(id)}, {"key": lockKey}, {"status": lockStatusLocked},
+		{"owner": ss.owner}}}
 }
@@ -0,0 +1,101 @@
+package mongo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewLockerWithOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		opt       LockerOptions
+		wantLease time.Duration
+		wantOwner string
+		wantErr   bool
+	}{
+		{name: "OK", opt: LockerOptions{Table: "lock", Owner: "o", Lease: time.Minute}, wantLease: time.Minute,
+			wantOwner: "o"},
+		{name: "Defaults", opt: LockerOptions{Table: "lock"}, wantLease: 5 * time.Minute},
+		{name: "No table", opt: LockerOptions{}, wantErr: true},
+		{name: "Wrong lease", opt: LockerOptions{Table: "lock", Lease: -time.Second}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewLockerWithOptions(&SessionProvider{}, tt.opt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewLockerWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantLease, got.lease)
+				if tt.wantOwner != "" {
+					assert.Equal(t, tt.wantOwner, got.Owner())
+				} else {
+					assert.NotEmpty(t, got.Owner())
+				}
+			}
+		})
+	}
+}
+
+func Test_defaultOwner(t *testing.T) {
+	o1, o2 := defaultOwner(), defaultOwner()
+	assert.NotEqual(t, o1, o2)
+	assert.True(t, strings.Contains(o1, "-"))
+}
+
+func TestLocker_ownedFilter(t *testing.T) {
+	l, _ := NewLockerWithOptions(&SessionProvider{}, LockerOptions{Table: "lock", Owner: "o"})
+	assert.Equal(t, bson.M{"$and": []bson.M{{"ID": "id"}, {"key": "k"}, {"status": 1}, {"owner": "o"}}},
+		l.ownedFilter(" id$", "k"))
+}
+
+func Test_lockableFilter(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"status": 0},
+		{"status": 1, "expiresAt": bson.M{"$lt": now}},
+		{"status": 1, "expiresAt": bson.M{"$exists": false}, "updated": bson.M{"$lt": now.Add(-time.Minute)}},
+	}}, lockableFilter(now, time.Minute))
+}
+
+func Test_legacyNoTimeFilter(t *testing.T) {
+	assert.Equal(t, bson.M{"status": 1, "expiresAt": bson.M{"$exists": false}, "updated": bson.M{"$exists": false}},
+		legacyNoTimeFilter())
+}
+
+func Test_renewInterval(t *testing.T) {
+	tests := []struct {
+		name  string
+		lease time.Duration
+		want  time.Duration
+	}{
+		{name: "Minutes", lease: 3 * time.Minute, want: time.Minute},
+		{name: "Short", lease: 3, want: 1},
+		{name: "Too short", lease: 2, want: 1},
+		{name: "Nanosecond", lease: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, renewInterval(tt.lease))
+		})
+	}
+}
+
+func TestLocker_KeepAlive_ShortLease(t *testing.T) {
+	l, err := NewLockerWithOptions(&SessionProvider{}, LockerOptions{Table: "lock", Lease: 1})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(test.Ctx(t))
+	cancel()
+	select {
+	case <-l.KeepAlive(ctx, "id", "k"):
+	case <-time.After(time.Second):
+		assert.Fail(t, "KeepAlive did not stop")
+	}
+}
@@ -0,0 +1,57 @@
+package mongo
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+//PoolStats keeps connection pool statistics
+type PoolStats struct {
+	// Open is the number of open connections
+	Open int64
+	// InUse is the number of checked out connections
+	InUse int64
+	// CheckOutFailed is the number of failed checkouts since start
+	CheckOutFailed int64
+	// Cleared is the number of pool clears since start, it happens on network errors
+	Cleared int64
+}
+
+// String returns stats for logging
+func (ps PoolStats) String() string {
+	return fmt.Sprintf("open=%d, inUse=%d, checkOutFailed=%d, cleared=%d", ps.Open, ps.InUse, ps.CheckOutFailed,
+		ps.Cleared)
+}
+
+// poolStats collects pool events
+type poolStats struct {
+	open, inUse, failed, cleared atomic.Int64
+}
+
+func (p *poolStats) monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: p.event}
+}
+
+func (p *poolStats) event(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		p.open.Add(1)
+	case event.ConnectionClosed:
+		p.open.Add(-1)
+	case event.GetSucceeded:
+		p.inUse.Add(1)
+	case event.ConnectionReturned:
+		p.inUse.Add(-1)
+	case event.GetFailed:
+		p.failed.Add(1)
+	case event.PoolCleared:
+		p.cleared.Add(1)
+	}
+}
+
+func (p *poolStats) stats() PoolStats {
+	return PoolStats{Open: p.open.Load(), InUse: p.inUse.Load(), CheckOutFailed: p.failed.Load(),
+		Cleared: p.cleared.Load()}
+}
@@ -2,21 +2,37 @@ package mongo
 
 import (
 	"context"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/airenas/go-app/pkg/goapp"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/x/bsonx"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
-//IndexData keeps index creation data
+// maxPingFailures is the number of consecutive failed pings after which the client is recreated
+const maxPingFailures = 3
+
+//IndexData keeps index creation data.
+//Existing index with the same name or keys is recreated if its options differ
 type IndexData struct {
-	Table  string
+	Table string
+	// Fields are index keys, field with "-" prefix is descending, e.g. []string{"ID", "-updated"}
 	Fields []string
 	Unique bool
+	// Name of the index, defaults to mongo generated <field>_<direction>...
+	Name string
+	// ExpireAfter makes TTL index: mongo removes records after the time set in the (single) date field.
+	// Zero expires records at the time in the field, nil - not a TTL index
+	ExpireAfter *time.Duration
+	// PartialFilter indexes only matching records. Indexes without it are sparse
+	PartialFilter bson.D
 }
 
 //NewIndexData creates index data
@@ -24,64 +40,234 @@ func NewIndexData(table string, field string, unique bool) IndexData {
 	return IndexData{Table: table, Fields: []string{field}, Unique: unique}
 }
 
+//NewTTLIndexData creates TTL index data, mongo removes records when the date in the field is older than ttl.
+//Use 0 ttl to remove records at the date in the field
+func NewTTLIndexData(table string, field string, ttl time.Duration) IndexData {
+	return IndexData{Table: table, Fields: []string{field}, ExpireAfter: &ttl}
+}
+
 //SessionProvider connects and provides session for mongo DB
 type SessionProvider struct {
 	client  *mongo.Client
 	URL     string
 	store   string
 	indexes []IndexData
+	opt     SessionProviderOptions
 	m       sync.Mutex // struct field mutex
+	pool    poolStats
+	// pingFailures counts consecutive failed pings of the current client
+	pingFailures int
+}
+
+//SessionProviderOptions keeps SessionProvider settings, zero values leave the URL's or driver's defaults
+type SessionProviderOptions struct {
+	URL     string
+	Store   string
+	Indexes []IndexData
+	// AppName is shown in mongo logs and currentOp
+	AppName                  string
+	MinPoolSize, MaxPoolSize uint64
+	MaxConnIdleTime          time.Duration
+	ConnectTimeout           time.Duration
+	ServerSelectionTimeout   time.Duration
+	// ReadPreference is a mode: primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	ReadPreference string
+	// WriteConcern is "majority" or the number of nodes, e.g. "1"
+	WriteConcern string
+	// WriteTimeout limits waiting for the write concern
+	WriteTimeout time.Duration
+	RetryWrites  *bool
+	RetryReads   *bool
 }
 
 //NewSessionProvider creates Mongo session provider
 func NewSessionProvider(url string, indexes []IndexData, store string) (*SessionProvider, error) {
-	if url == "" {
+	return NewSessionProviderWithOptions(SessionProviderOptions{URL: url, Indexes: indexes, Store: store})
+}
+
+//NewSessionProviderWithOptions creates Mongo session provider with options
+func NewSessionProviderWithOptions(opt SessionProviderOptions) (*SessionProvider, error) {
+	if opt.URL == "" {
 		return nil, errors.New("no Mongo url provided")
 	}
-	return &SessionProvider{URL: url, indexes: indexes, store: store}, nil
+	res := &SessionProvider{URL: opt.URL, indexes: opt.Indexes, store: opt.Store, opt: opt}
+	if _, err := res.clientOptions(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (sp *SessionProvider) clientOptions() (*options.ClientOptions, error) {
+	opt := sp.opt
+	res := options.Client().ApplyURI(sp.URL).SetPoolMonitor(sp.pool.monitor())
+	if opt.AppName != "" {
+		res.SetAppName(opt.AppName)
+	}
+	if opt.MinPoolSize > 0 {
+		res.SetMinPoolSize(opt.MinPoolSize)
+	}
+	if opt.MaxPoolSize > 0 {
+		res.SetMaxPoolSize(opt.MaxPoolSize)
+	}
+	if opt.MinPoolSize > 0 && opt.MaxPoolSize > 0 && opt.MinPoolSize > opt.MaxPoolSize {
+		return nil, errors.Errorf("wrong pool size: min %d > max %d", opt.MinPoolSize, opt.MaxPoolSize)
+	}
+	if opt.MaxConnIdleTime > 0 {
+		res.SetMaxConnIdleTime(opt.MaxConnIdleTime)
+	}
+	if opt.ConnectTimeout > 0 {
+		res.SetConnectTimeout(opt.ConnectTimeout)
+	}
+	if opt.ServerSelectionTimeout > 0 {
+		res.SetServerSelectionTimeout(opt.ServerSelectionTimeout)
+	}
+	if opt.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(opt.ReadPreference)
+		if err != nil {
+			return nil, errors.Wrapf(err, "wrong read preference '%s'", opt.ReadPreference)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, errors.Wrapf(err, "wrong read preference '%s'", opt.ReadPreference)
+		}
+		res.SetReadPreference(rp)
+	}
+	if opt.WriteConcern != "" || opt.WriteTimeout > 0 {
+		wc, err := writeConcern(opt.WriteConcern, opt.WriteTimeout)
+		if err != nil {
+			return nil, err
+		}
+		res.SetWriteConcern(wc)
+	}
+	if opt.RetryWrites != nil {
+		res.SetRetryWrites(*opt.RetryWrites)
+	}
+	if opt.RetryReads != nil {
+		res.SetRetryReads(*opt.RetryReads)
+	}
+	return res, nil
+}
+
+func writeConcern(w string, timeout time.Duration) (*writeconcern.WriteConcern, error) {
+	var opts []writeconcern.Option
+	switch w {
+	case "":
+	case "majority":
+		opts = append(opts, writeconcern.WMajority())
+	default:
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("wrong write concern '%s'", w)
+		}
+		opts = append(opts, writeconcern.W(n))
+	}
+	if timeout > 0 {
+		opts = append(opts, writeconcern.WTimeout(timeout))
+	}
+	return writeconcern.New(opts...), nil
 }
 
 //Close closes mongo session
 func (sp *SessionProvider) Close() {
+	sp.m.Lock()
+	defer sp.m.Unlock()
+
 	if sp.client != nil {
-		ctx, cancel := mongoContext()
+		ctx, cancel := mongoContext(context.Background())
 		defer cancel()
-		sp.client.Disconnect(ctx)
+		_ = sp.client.Disconnect(ctx)
+		sp.client = nil
 	}
 }
 
-//NewSession creates mongo session
-func (sp *SessionProvider) NewSession() (mongo.Session, error) {
+//NewSession creates mongo session, ctx is used for connecting if there is no client.
+//The client is recreated after it was dropped by Health
+func (sp *SessionProvider) NewSession(ctx context.Context) (mongo.Session, error) {
 	sp.m.Lock()
 	defer sp.m.Unlock()
 
-	if sp.client == nil {
-		goapp.Log.Info().Msg("Dial mongo: " + goapp.HidePass(sp.URL))
-		ctx, cancel := mongoContext()
-		defer cancel()
-		client, err := mongo.Connect(ctx, options.Client().ApplyURI(sp.URL))
-		if err != nil {
-			return nil, errors.Wrap(err, "can't dial to mongo")
-		}
-		sp.client = client
-
-		err = checkIndexes(sp.client, sp.indexes, sp.store)
-		if err != nil {
-			sp.client = nil
-			return nil, errors.Wrap(err, "can't create indexes")
-		}
+	if err := sp.connect(ctx); err != nil {
+		return nil, err
 	}
 	return sp.client.StartSession()
 }
 
-func checkIndexes(s *mongo.Client, indexes []IndexData, store string) error {
+// connect creates the client if there is none, must be called under lock
+func (sp *SessionProvider) connect(ctx context.Context) error {
+	if sp.client != nil {
+		return nil
+	}
+	goapp.Log.Info().Msg("Dial mongo: " + goapp.HidePass(sp.URL))
+	ctx, cancel := mongoContext(ctx)
+	defer cancel()
+	opt, err := sp.clientOptions()
+	if err != nil {
+		return err
+	}
+	client, err := mongo.Connect(ctx, opt)
+	if err != nil {
+		return errors.Wrap(err, "can't dial to mongo")
+	}
+	err = checkIndexes(ctx, client, sp.indexes, sp.store)
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return errors.Wrap(err, "can't create indexes")
+	}
+	sp.client = client
+	sp.pingFailures = 0
+	return nil
+}
+
+// pingFailed counts the failure of the client's ping.
+// After maxPingFailures consecutive failures the client is dropped, the next NewSession reconnects
+func (sp *SessionProvider) pingFailed(client *mongo.Client, err error) {
+	sp.m.Lock()
+	defer sp.m.Unlock()
+
+	if sp.client != client || client == nil || !unusable(err) {
+		return
+	}
+	sp.pingFailures++
+	if sp.pingFailures < maxPingFailures {
+		return
+	}
+	goapp.Log.Warn().Int("failures", sp.pingFailures).Msg("dropping mongo client")
+	ctx, cancel := mongoContext(context.Background())
+	defer cancel()
+	_ = client.Disconnect(ctx)
+	sp.client = nil
+	sp.pingFailures = 0
+}
+
+// pingOK clears the failure count of the client
+func (sp *SessionProvider) pingOK(client *mongo.Client) {
+	sp.m.Lock()
+	defer sp.m.Unlock()
+
+	if sp.client == client {
+		sp.pingFailures = 0
+	}
+}
+
+// unusable reports if the error means the server can't be reached.
+// Server selection fails if no server is reachable in time, e.g. mongo is down.
+// Errors of canceled calls are not counted
+func unusable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var sErr topology.ServerSelectionError
+	return errors.As(err, &sErr) || mongo.IsNetworkError(err)
+}
+
+func checkIndexes(ctx context.Context, s *mongo.Client, indexes []IndexData, store string) error {
 	session, err := s.StartSession()
 	if err != nil {
 		return errors.Wrap(err, "can't init session")
 	}
 	defer session.EndSession(context.Background())
 	for _, index := range indexes {
-		err := checkIndex(session, index, store)
+		err := checkIndex(ctx, session, index, store)
 		if err != nil {
 			return errors.Wrapf(err, "can't create index: %s:%v", index.Table, index.Fields)
 		}
@@ -89,31 +275,53 @@ func checkIndexes(s *mongo.Client, indexes []IndexData, store string) error {
 	return nil
 }
 
-func checkIndex(s mongo.Session, indexData IndexData, store string) error {
-	c := s.Client().Database(store).Collection(indexData.Table)
-	keys := bsonx.Doc{}
-	for _, f := range indexData.Fields {
-		keys = keys.Append(f, bsonx.Int32(int32(1)))
-	}
-	index := mongo.IndexModel{
-		Keys:    keys,
-		Options: options.Index().SetUnique(indexData.Unique).SetBackground(true).SetSparse(true),
-	}
-	_, err := c.Indexes().CreateOne(context.Background(), index)
-	return err
+func checkIndex(ctx context.Context, s mongo.Session, indexData IndexData, store string) error {
+	return ensureIndex(ctx, s.Client().Database(store).Collection(indexData.Table), indexData)
+}
+
+//HealthInfo keeps the DB health check result
+type HealthInfo struct {
+	Ping time.Duration
+	Pool PoolStats
 }
 
 // Healthy checks if mongo DB is up
 func (sp *SessionProvider) Healthy() error {
-	session, err := sp.NewSession()
+	ctx, cancel := mongoContext(context.Background())
+	defer cancel()
+	_, err := sp.Health(ctx)
+	return err
+}
+
+//Health pings mongo DB and returns ping time and connection pool statistics.
+//The client is dropped after 3 consecutive server selection or network failures, so the next call reconnects
+func (sp *SessionProvider) Health(ctx context.Context) (*HealthInfo, error) {
+	session, err := sp.NewSession(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer session.EndSession(context.Background())
-	return session.Client().Ping(context.Background(), nil)
+	client := session.Client()
+	start := time.Now()
+	err = client.Ping(ctx, nil)
+	res := &HealthInfo{Ping: time.Since(start), Pool: sp.pool.stats()}
+	if err != nil {
+		sp.pingFailed(client, err)
+		return res, errors.Wrapf(err, "ping failed, pool: %s", res.Pool.String())
+	}
+	sp.pingOK(client)
+	return res, nil
 }
 
-func mongoContext() (context.Context, context.CancelFunc) {
-	return context.WithTimeout(context.Background(), 5*time.Second)
+// mongoContext limits ctx by the default timeout if ctx has no deadline
+func mongoContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withDefaultTimeout(ctx, 5*time.Second)
+}
+
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
@@ -1 +1,144 @@
 package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/clean"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+var (
+	_ clean.Cleaner        = (*CleanRecord)(nil)
+	_ clean.OldIDsProvider = (*CleanIDsProvider)(nil)
+)
+
+func Test_withDefaultTimeout(t *testing.T) {
+	ctx, cancel := withDefaultTimeout(context.Background(), time.Second)
+	defer cancel()
+	d, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.InDelta(t, time.Second, time.Until(d), float64(100*time.Millisecond))
+
+	pCtx, pCancel := context.WithTimeout(test.Ctx(t), time.Hour)
+	defer pCancel()
+	ctx, cancel = withDefaultTimeout(pCtx, time.Second)
+	defer cancel()
+	d, _ = ctx.Deadline()
+	pd, _ := pCtx.Deadline()
+	assert.Equal(t, pd, d)
+}
+
+func TestNewSessionProviderWithOptions(t *testing.T) {
+	retry := false
+	tests := []struct {
+		name    string
+		opt     SessionProviderOptions
+		wantErr bool
+	}{
+		{name: "OK", opt: SessionProviderOptions{URL: "mongodb://localhost:27017"}},
+		{name: "Full", opt: SessionProviderOptions{URL: "mongodb://localhost:27017", AppName: "app", MinPoolSize: 1,
+			MaxPoolSize: 10, MaxConnIdleTime: time.Minute, ReadPreference: "secondaryPreferred", WriteConcern: "majority",
+			WriteTimeout: time.Second, RetryWrites: &retry}},
+		{name: "No URL", opt: SessionProviderOptions{}, wantErr: true},
+		{name: "Wrong pool", opt: SessionProviderOptions{URL: "mongodb://localhost:27017", MinPoolSize: 10, MaxPoolSize: 1},
+			wantErr: true},
+		{name: "Wrong read pref", opt: SessionProviderOptions{URL: "mongodb://localhost:27017", ReadPreference: "olia"},
+			wantErr: true},
+		{name: "Wrong write concern", opt: SessionProviderOptions{URL: "mongodb://localhost:27017", WriteConcern: "olia"},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSessionProviderWithOptions(tt.opt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSessionProviderWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.NotNil(t, got)
+			}
+		})
+	}
+}
+
+func TestSessionProvider_clientOptions(t *testing.T) {
+	retry := false
+	sp, err := NewSessionProviderWithOptions(SessionProviderOptions{URL: "mongodb://localhost:27017", AppName: "app",
+		MaxPoolSize: 10, ReadPreference: "nearest", WriteConcern: "2", RetryWrites: &retry})
+	assert.Nil(t, err)
+	got, err := sp.clientOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, "app", *got.AppName)
+	assert.Equal(t, uint64(10), *got.MaxPoolSize)
+	assert.Equal(t, "nearest", got.ReadPreference.Mode().String())
+	assert.Equal(t, 2, got.WriteConcern.GetW())
+	assert.False(t, *got.RetryWrites)
+	assert.NotNil(t, got.PoolMonitor)
+}
+
+func Test_poolStats(t *testing.T) {
+	p := &poolStats{}
+	for _, e := range []string{event.ConnectionCreated, event.ConnectionCreated, event.ConnectionClosed,
+		event.GetSucceeded, event.GetSucceeded, event.ConnectionReturned, event.GetFailed, event.PoolCleared} {
+		p.monitor().Event(&event.PoolEvent{Type: e})
+	}
+	assert.Equal(t, PoolStats{Open: 1, InUse: 1, CheckOutFailed: 1, Cleared: 1}, p.stats())
+	assert.Equal(t, "open=1, inUse=1, checkOutFailed=1, cleared=1", p.stats().String())
+}
+
+func Test_unusable(t *testing.T) {
+	sErr := topology.ServerSelectionError{Wrapped: context.DeadlineExceeded}
+	tests := []struct {
+		name string
+		args error
+		want bool
+	}{
+		{name: "Nil", args: nil, want: false},
+		{name: "Server selection", args: sErr, want: true},
+		{name: "Wrapped", args: errors.Wrap(sErr, "olia"), want: true},
+		{name: "Network", args: mongo.CommandError{Labels: []string{"NetworkError"}}, want: true},
+		{name: "Canceled", args: topology.ServerSelectionError{Wrapped: context.Canceled}, want: false},
+		{name: "Other", args: errors.New("olia"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, unusable(tt.args))
+		})
+	}
+}
+
+func TestSessionProvider_pingFailed(t *testing.T) {
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:1"))
+	assert.Nil(t, err)
+	sErr := topology.ServerSelectionError{Wrapped: context.DeadlineExceeded}
+	sp := &SessionProvider{client: client}
+	for i := 0; i < maxPingFailures-1; i++ {
+		sp.pingFailed(client, sErr)
+	}
+	sp.pingFailed(client, errors.New("olia"))
+	assert.Equal(t, client, sp.client)
+	sp.pingOK(client)
+	for i := 0; i < maxPingFailures-1; i++ {
+		sp.pingFailed(client, sErr)
+	}
+	assert.Equal(t, client, sp.client)
+	sp.pingFailed(client, sErr)
+	assert.Nil(t, sp.client)
+	assert.Equal(t, 0, sp.pingFailures)
+
+	sp.client = client
+	other, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:1"))
+	assert.Nil(t, err)
+	for i := 0; i < maxPingFailures; i++ {
+		sp.pingFailed(other, sErr)
+	}
+	assert.Equal(t, client, sp.client)
+}
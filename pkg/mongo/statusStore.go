@@ -0,0 +1,178 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrNotFound is returned when there is no status record for the ID
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when the status record for the ID already exists
+	ErrExists = errors.New("already exists")
+	// ErrVersionConflict is returned when the record was updated by someone else
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+// Status keeps the job's state.
+// Status values are usually messages.InformTypeStarted, InformTypeFinished or InformTypeFailed
+type Status struct {
+	ID       string          `bson:"ID"`
+	Status   string          `bson:"status,omitempty"`
+	Progress int             `bson:"progress"`
+	Error    string          `bson:"error,omitempty"`
+	Version  int             `bson:"version"`
+	Created  time.Time       `bson:"created"`
+	Updated  time.Time       `bson:"updated"`
+	History  []StatusHistory `bson:"history,omitempty"`
+}
+
+// StatusHistory keeps the status transition
+type StatusHistory struct {
+	Status string    `bson:"status"`
+	At     time.Time `bson:"at"`
+	Error  string    `bson:"error,omitempty"`
+}
+
+// StatusUpdate keeps the changed values, nil or empty values are not changed
+type StatusUpdate struct {
+	// Status change is added to history
+	Status   string
+	Progress *int
+	Error    *string
+}
+
+// StatusStore keeps job statuses in mongo table.
+// Records have ID field and default _id, so CleanRecord and CleanIDsProvider can be used for the table
+type StatusStore struct {
+	sessionProvider *SessionProvider
+	table           string
+}
+
+// NewStatusStore creates StatusStore instance, add StatusIndexes to the SessionProvider
+func NewStatusStore(sessionProvider *SessionProvider, table string) (*StatusStore, error) {
+	if table == "" {
+		return nil, errors.New("no table")
+	}
+	if sessionProvider == nil {
+		return nil, errors.New("no session provider")
+	}
+	return &StatusStore{sessionProvider: sessionProvider, table: table}, nil
+}
+
+// StatusIndexes returns indexes for the status table.
+// Add NewTTLIndexData(table, "updated", ttl) to let mongo expire old records
+func StatusIndexes(table string) []IndexData {
+	return []IndexData{NewIndexData(table, "ID", true)}
+}
+
+// Create inserts the new status record with version 1
+func (ss *StatusStore) Create(ctx context.Context, id string, status string) (*Status, error) {
+	goapp.Log.Info().Msgf("Creating status %s: %s", id, status)
+	id = Sanitize(id)
+	if id == "" {
+		return nil, errors.New("no ID")
+	}
+
+	c, ctx, cancel, err := NewCollection(ctx, ss.sessionProvider, ss.table)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	res := &Status{ID: id, Status: status, Version: 1, Created: now, Updated: now}
+	if status != "" {
+		res.History = []StatusHistory{{Status: status, At: now}}
+	}
+	if _, err := c.InsertOne(ctx, res); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.Wrapf(ErrExists, "status %s", id)
+		}
+		return nil, errors.Wrap(err, "can't insert status")
+	}
+	return res, nil
+}
+
+// Get returns the status record or ErrNotFound
+func (ss *StatusStore) Get(ctx context.Context, id string) (*Status, error) {
+	c, ctx, cancel, err := NewCollection(ctx, ss.sessionProvider, ss.table)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var res Status
+	err = c.FindOne(ctx, bson.M{"ID": Sanitize(id)}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.Wrapf(ErrNotFound, "status %s", id)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load status")
+	}
+	return &res, nil
+}
+
+// Update changes the record if its version equals to version, returns the updated record.
+// ErrVersionConflict is returned if the record was changed after it was read
+func (ss *StatusStore) Update(ctx context.Context, id string, version int, upd StatusUpdate) (*Status, error) {
+	goapp.Log.Debug().Msgf("Updating status %s(v%d)", id, version)
+	doc, err := updateDoc(upd, time.Now().UTC().Truncate(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+
+	c, ctx, cancel, err := NewCollection(ctx, ss.sessionProvider, ss.table)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	id = Sanitize(id)
+	var res Status
+	err = c.FindOneAndUpdate(ctx, bson.M{"ID": id, "version": version}, doc,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		n, err := c.CountDocuments(ctx, bson.M{"ID": id})
+		if err != nil {
+			return nil, errors.Wrap(err, "can't count status")
+		}
+		if n == 0 {
+			return nil, errors.Wrapf(ErrNotFound, "status %s", id)
+		}
+		return nil, errors.Wrapf(ErrVersionConflict, "status %s(v%d)", id, version)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't update status")
+	}
+	return &res, nil
+}
+
+func updateDoc(upd StatusUpdate, now time.Time) (bson.M, error) {
+	set := bson.M{"updated": now}
+	if upd.Progress != nil {
+		if *upd.Progress < 0 || *upd.Progress > 100 {
+			return nil, errors.Errorf("wrong progress %d, expected [0, 100]", *upd.Progress)
+		}
+		set["progress"] = *upd.Progress
+	}
+	if upd.Error != nil {
+		set["error"] = *upd.Error
+	}
+	res := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if upd.Status != "" {
+		set["status"] = upd.Status
+		h := StatusHistory{Status: upd.Status, At: now}
+		if upd.Error != nil {
+			h.Error = *upd.Error
+		}
+		res["$push"] = bson.M{"history": h}
+	}
+	return res, nil
+}
@@ -0,0 +1,70 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewStatusStore(t *testing.T) {
+	type args struct {
+		sessionProvider *SessionProvider
+		table           string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{name: "OK", args: args{sessionProvider: &SessionProvider{}, table: "status"}, wantErr: false},
+		{name: "Fail", args: args{sessionProvider: &SessionProvider{}, table: ""}, wantErr: true},
+		{name: "Fail", args: args{sessionProvider: nil, table: "status"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStatusStore(tt.args.sessionProvider, tt.args.table)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewStatusStore() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.NotNil(t, got)
+			}
+		})
+	}
+}
+
+func Test_updateDoc(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	p10, p101, e := 10, 101, "olia"
+	tests := []struct {
+		name    string
+		upd     StatusUpdate
+		want    bson.M
+		wantErr bool
+	}{
+		{name: "Empty", want: bson.M{"$set": bson.M{"updated": now}, "$inc": bson.M{"version": 1}}},
+		{name: "Progress", upd: StatusUpdate{Progress: &p10},
+			want: bson.M{"$set": bson.M{"updated": now, "progress": 10}, "$inc": bson.M{"version": 1}}},
+		{name: "Status", upd: StatusUpdate{Status: "Failed", Error: &e},
+			want: bson.M{"$set": bson.M{"updated": now, "status": "Failed", "error": "olia"}, "$inc": bson.M{"version": 1},
+				"$push": bson.M{"history": StatusHistory{Status: "Failed", At: now, Error: "olia"}}}},
+		{name: "Wrong progress", upd: StatusUpdate{Progress: &p101}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := updateDoc(tt.upd, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("updateDoc() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatusIndexes(t *testing.T) {
+	assert.Equal(t, []IndexData{{Table: "status", Fields: []string{"ID"}, Unique: true}}, StatusIndexes("status"))
+}
@@ -21,16 +21,31 @@ func SkipNoDocErr(err error) error {
 	return err
 }
 
-// NewCollection initiates new collection for mongo
-func NewCollection(pr *SessionProvider, tName string) (*mongo.Collection, context.Context, func(), error) {
-	session, err := pr.NewSession()
+// NewCollection initiates new collection for mongo.
+// The returned ctx is limited by the default 5s timeout if ctx has no deadline
+func NewCollection(ctx context.Context, pr *SessionProvider, tName string) (*mongo.Collection, context.Context, func(), error) {
+	session, err := pr.NewSession(ctx)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "can't init new session")
 	}
 	res := session.Client().Database(pr.store).Collection(tName)
-	ctx, cancel := mongoContext()
+	ctx, cancel := mongoContext(ctx)
 	return res, ctx, func() {
 		session.EndSession(context.Background())
 		cancel()
 	}, nil
 }
+
+// RunTransaction runs fn in a transaction, pass fn's ctx to all calls that must be committed together,
+// e.g. StatusStore.Update and outbox.Outbox.Add. Requires mongo replica set
+func RunTransaction(ctx context.Context, pr *SessionProvider, fn func(ctx context.Context) error) error {
+	session, err := pr.NewSession(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't init new session")
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,160 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/async-api/pkg/mongo"
+	"github.com/airenas/async-api/pkg/rabbit"
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Record is the message kept in the outbox table until Relay publishes it
+type Record struct {
+	ID              primitive.ObjectID     `bson:"_id,omitempty"`
+	Exchange        string                 `bson:"exchange"`
+	Key             string                 `bson:"key"`
+	Headers         map[string]interface{} `bson:"headers,omitempty"`
+	ContentType     string                 `bson:"contentType,omitempty"`
+	ContentEncoding string                 `bson:"contentEncoding,omitempty"`
+	ReplyTo         string                 `bson:"replyTo,omitempty"`
+	CorrelationID   string                 `bson:"correlationID,omitempty"`
+	Body            []byte                 `bson:"body"`
+	Created         time.Time              `bson:"created"`
+	Sent            bool                   `bson:"sent"`
+	SentAt          *time.Time             `bson:"sentAt,omitempty"`
+	Attempts        int                    `bson:"attempts"`
+	LastError       string                 `bson:"lastError,omitempty"`
+	// Rejects is the number of publishings rejected by the broker
+	Rejects int `bson:"rejects,omitempty"`
+	// Parked is set when the message was rejected RelayOptions.MaxAttempts times, unset it to retry the message
+	Parked bool `bson:"parked,omitempty"`
+}
+
+// Outbox writes messages into mongo table instead of sending them.
+// Call Add with the same ctx as the state change, e.g. inside mongo.RunTransaction,
+// so the message is stored only if the state is committed. Relay publishes the stored messages
+type Outbox struct {
+	sessionProvider *mongo.SessionProvider
+	sender          *rabbit.Sender
+	table           string
+}
+
+// New creates Outbox instance, the sender encodes messages the same way as it does for Send
+func New(sessionProvider *mongo.SessionProvider, sender *rabbit.Sender, table string) (*Outbox, error) {
+	if sessionProvider == nil {
+		return nil, errors.New("no session provider")
+	}
+	if sender == nil || sender.ChannelProvider == nil {
+		return nil, errors.New("no sender")
+	}
+	if table == "" {
+		return nil, errors.New("no table")
+	}
+	return &Outbox{sessionProvider: sessionProvider, sender: sender, table: table}, nil
+}
+
+// Indexes returns indexes for the outbox table, sent records are removed by mongo after keepSent
+func Indexes(table string, keepSent time.Duration) []mongo.IndexData {
+	return []mongo.IndexData{{Table: table, Fields: []string{"sent", "_id"}},
+		mongo.NewTTLIndexData(table, "sentAt", keepSent)}
+}
+
+// Add stores the message for sending to the queue
+func (o *Outbox) Add(ctx context.Context, message messages.Message, queue string) error {
+	return o.AddWithReply(ctx, message, queue, "")
+}
+
+// AddWithReply stores the message for sending to the queue with the reply queue set
+func (o *Outbox) AddWithReply(ctx context.Context, message messages.Message, queue string, replyQueue string) error {
+	key, msg, err := o.sender.Prepare(ctx, message, queue, replyQueue)
+	if err != nil {
+		return err
+	}
+	rec := newRecord("", key, msg, time.Now().UTC())
+	goapp.Log.Debug().Msgf("Adding message for %s to outbox", key)
+
+	c, ctx, cancel, err := mongo.NewCollection(ctx, o.sessionProvider, o.table)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if _, err := c.InsertOne(ctx, rec); err != nil {
+		return errors.Wrap(err, "can't insert outbox record")
+	}
+	return nil
+}
+
+func (o *Outbox) pending(ctx context.Context, limit int) ([]*Record, error) {
+	c, ctx, cancel, err := mongo.NewCollection(ctx, o.sessionProvider, o.table)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := c.Find(ctx, bson.M{"sent": false, "parked": bson.M{"$ne": true}},
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select from "+o.table)
+	}
+	res := make([]*Record, 0)
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, errors.Wrap(err, "can't read outbox records")
+	}
+	return res, nil
+}
+
+func (o *Outbox) markSent(ctx context.Context, id primitive.ObjectID) error {
+	return o.update(ctx, id, bson.M{"$set": bson.M{"sent": true, "sentAt": time.Now().UTC()},
+		"$inc": bson.M{"attempts": 1}, "$unset": bson.M{"lastError": ""}})
+}
+
+func (o *Outbox) markFailed(ctx context.Context, id primitive.ObjectID, sendErr error, rejected, park bool) error {
+	set, inc := bson.M{"lastError": sendErr.Error()}, bson.M{"attempts": 1}
+	if rejected {
+		inc["rejects"] = 1
+	}
+	if park {
+		set["parked"] = true
+	}
+	return o.update(ctx, id, bson.M{"$set": set, "$inc": inc})
+}
+
+func (o *Outbox) update(ctx context.Context, id primitive.ObjectID, doc bson.M) error {
+	c, ctx, cancel, err := mongo.NewCollection(ctx, o.sessionProvider, o.table)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": id}, doc); err != nil {
+		return errors.Wrapf(err, "can't update outbox record %s", id.Hex())
+	}
+	return nil
+}
+
+func newRecord(exchange, key string, msg amqp.Publishing, now time.Time) *Record {
+	return &Record{Exchange: exchange, Key: key, Headers: msg.Headers, ContentType: msg.ContentType,
+		ContentEncoding: msg.ContentEncoding, ReplyTo: msg.ReplyTo, CorrelationID: msg.CorrelationId,
+		Body: msg.Body, Created: now}
+}
+
+// publishing restores the message, the record's ID is used as MessageId, so consumers can drop duplicates
+func (r *Record) publishing() amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         amqp.Table(r.Headers),
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     r.ContentType,
+		ContentEncoding: r.ContentEncoding,
+		ReplyTo:         r.ReplyTo,
+		CorrelationId:   r.CorrelationID,
+		MessageId:       r.ID.Hex(),
+		Timestamp:       r.Created,
+		Body:            r.Body,
+	}
+}
@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/async-api/pkg/mongo"
+	"github.com/airenas/async-api/pkg/rabbit"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNew(t *testing.T) {
+	sp, err := mongo.NewSessionProvider("mongodb://localhost:27017", nil, "db")
+	assert.Nil(t, err)
+	sender := rabbit.NewSender(&rabbit.ChannelProvider{})
+	tests := []struct {
+		name    string
+		sp      *mongo.SessionProvider
+		sender  *rabbit.Sender
+		table   string
+		wantErr bool
+	}{
+		{name: "OK", sp: sp, sender: sender, table: "outbox"},
+		{name: "No session provider", sender: sender, table: "outbox", wantErr: true},
+		{name: "No sender", sp: sp, table: "outbox", wantErr: true},
+		{name: "No channel provider", sp: sp, sender: &rabbit.Sender{}, table: "outbox", wantErr: true},
+		{name: "No table", sp: sp, sender: sender, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.sp, tt.sender, tt.table)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.NotNil(t, got)
+			}
+		})
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	got := Indexes("outbox", time.Hour)
+	assert.Equal(t, []string{"sent", "_id"}, got[0].Fields)
+	assert.Equal(t, []string{"sentAt"}, got[1].Fields)
+	assert.Equal(t, time.Hour, *got[1].ExpireAfter)
+}
+
+func TestRecord_publishing(t *testing.T) {
+	sender := rabbit.NewSender(&rabbit.ChannelProvider{})
+	key, msg, err := sender.Prepare(test.Ctx(t), messages.NewQueueMessageFromM(&messages.QueueMessage{ID: "1"}), "q", "r")
+	assert.Nil(t, err)
+	msg.CorrelationId = "c"
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	rec := newRecord("", key, msg, now)
+
+	// check the record is restored after storing
+	b, err := bson.Marshal(rec)
+	assert.Nil(t, err)
+	var got Record
+	assert.Nil(t, bson.Unmarshal(b, &got))
+	assert.False(t, got.Sent)
+	got.ID = primitive.NewObjectID()
+
+	p := got.publishing()
+	assert.Equal(t, "q", got.Key)
+	assert.Equal(t, msg.Body, p.Body)
+	assert.Equal(t, "r", p.ReplyTo)
+	assert.Equal(t, "c", p.CorrelationId)
+	assert.Equal(t, msg.ContentType, p.ContentType)
+	assert.Equal(t, got.ID.Hex(), p.MessageId)
+	assert.Equal(t, uint8(amqp.Persistent), p.DeliveryMode)
+	assert.Equal(t, now, p.Timestamp)
+}
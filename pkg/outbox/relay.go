@@ -0,0 +1,183 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/airenas/async-api/pkg/mongo"
+	"github.com/airenas/async-api/pkg/rabbit"
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const lockKey = "outbox-relay"
+
+// RelayOptions keeps Relay settings
+type RelayOptions struct {
+	// Interval is the pause between outbox polls, defaults to 1s
+	Interval time.Duration
+	// BatchSize is the max number of messages published in one poll, defaults to 100
+	BatchSize int
+	// LockID is the lock record's ID, defaults to the outbox table name.
+	// Relays with the same LockID do not publish in parallel
+	LockID string
+	// MaxAttempts is the number of broker rejections (rabbit.ErrNack) after which the message is parked,
+	// defaults to 10. Other failures, e.g. unroutable message, confirm timeout or broker outage, are retried forever.
+	// Parked messages are kept in the table with the last error and are not published anymore
+	MaxAttempts int
+}
+
+type store interface {
+	pending(ctx context.Context, limit int) ([]*Record, error)
+	markSent(ctx context.Context, id primitive.ObjectID) error
+	markFailed(ctx context.Context, id primitive.ObjectID, sendErr error, rejected, park bool) error
+}
+
+type publisher interface {
+	Publish(exchange, key string, msg amqp.Publishing) error
+}
+
+type locker interface {
+	Lock(ctx context.Context, id string, lockKey string) error
+	KeepAlive(ctx context.Context, id string, lockKey string) <-chan struct{}
+	UnLock(ctx context.Context, id string, lockKey string, value *int) error
+}
+
+// Relay publishes outbox messages in the order they were added and marks them sent.
+// A message rejected by the broker MaxAttempts times is parked, so it does not block the later ones.
+// It is safe to run in several replicas: only the Locker's owner publishes.
+// Delivery is at least once, a message may be resent if the relay dies after publishing,
+// consumers can drop duplicates by MessageId
+type Relay struct {
+	store     store
+	publisher publisher
+	locker    locker
+	opt       RelayOptions
+}
+
+// NewRelay creates Relay instance, the outbox sender's ChannelProvider must have publisher confirms on
+func NewRelay(outbox *Outbox, locker *mongo.Locker, opt RelayOptions) (*Relay, error) {
+	if outbox == nil {
+		return nil, errors.New("no outbox")
+	}
+	if locker == nil {
+		return nil, errors.New("no locker")
+	}
+	if !outbox.sender.ChannelProvider.Confirms() {
+		return nil, errors.New("publisher confirms are required")
+	}
+	if opt.Interval < 0 {
+		return nil, errors.Errorf("wrong interval %v", opt.Interval)
+	}
+	if opt.Interval == 0 {
+		opt.Interval = time.Second
+	}
+	if opt.BatchSize < 0 {
+		return nil, errors.Errorf("wrong batch size %d", opt.BatchSize)
+	}
+	if opt.BatchSize == 0 {
+		opt.BatchSize = 100
+	}
+	if opt.LockID == "" {
+		opt.LockID = outbox.table
+	}
+	if opt.MaxAttempts < 0 {
+		return nil, errors.Errorf("wrong max attempts %d", opt.MaxAttempts)
+	}
+	if opt.MaxAttempts == 0 {
+		opt.MaxAttempts = 10
+	}
+	return &Relay{store: outbox, publisher: outbox.sender.ChannelProvider, locker: locker, opt: opt}, nil
+}
+
+// Start starts publishing in background, the returned channel is closed when the relay stops after ctx is canceled
+func (r *Relay) Start(ctx context.Context) <-chan struct{} {
+	goapp.Log.Info().Str("lock", r.opt.LockID).Msgf("Starting outbox relay every %v", r.opt.Interval)
+	res := make(chan struct{}, 2)
+	go func() {
+		defer close(res)
+		r.run(ctx)
+	}()
+	return res
+}
+
+func (r *Relay) run(ctx context.Context) {
+	ticker := time.NewTicker(r.opt.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := r.relay(ctx)
+			if err != nil {
+				goapp.Log.Error().Err(err).Msg("outbox relay failed")
+			}
+			if n > 0 {
+				goapp.Log.Info().Int("count", n).Msg("Published outbox messages")
+			}
+		case <-ctx.Done():
+			goapp.Log.Info().Msg("Stopped outbox relay")
+			return
+		}
+	}
+}
+
+// relay publishes one batch under the lock, returns the number of published messages.
+// Publishing stops on the first failure to keep the messages' order, unless the failed message is parked
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	err := r.locker.Lock(ctx, r.opt.LockID, lockKey)
+	if errors.Is(err, mongo.ErrLocked) {
+		goapp.Log.Debug().Msg("Outbox relay is locked by other instance")
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "can't lock")
+	}
+	lCtx, cancel := context.WithCancel(ctx)
+	alive := r.locker.KeepAlive(lCtx, r.opt.LockID, lockKey)
+	defer func() {
+		cancel()
+		<-alive
+		free := 0
+		if err := r.locker.UnLock(context.WithoutCancel(ctx), r.opt.LockID, lockKey, &free); err != nil {
+			goapp.Log.Warn().Err(err).Msg("can't unlock outbox relay")
+		}
+	}()
+
+	recs, err := r.store.pending(lCtx, r.opt.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	res := 0
+	for _, rec := range recs {
+		select {
+		case <-alive:
+			return res, errors.Wrap(mongo.ErrNotOwner, "lock lost")
+		default:
+		}
+		if err := lCtx.Err(); err != nil {
+			return res, err
+		}
+		if err := r.publisher.Publish(rec.Exchange, rec.Key, rec.publishing()); err != nil {
+			rejected := errors.Is(err, rabbit.ErrNack)
+			park := rejected && rec.Rejects+1 >= r.opt.MaxAttempts
+			mErr := r.store.markFailed(lCtx, rec.ID, err, rejected, park)
+			if mErr != nil {
+				goapp.Log.Error().Err(mErr).Send()
+			}
+			if mErr != nil || !park {
+				return res, errors.Wrapf(err, "can't publish %s", rec.ID.Hex())
+			}
+			goapp.Log.Error().Err(err).Str("id", rec.ID.Hex()).Int("rejects", rec.Rejects+1).
+				Msg("Parked outbox message")
+			continue
+		}
+		// the message is published, mark it even if ctx is canceled
+		if err := r.store.markSent(context.WithoutCancel(lCtx), rec.ID); err != nil {
+			return res, err
+		}
+		res++
+	}
+	return res, nil
+}
@@ -0,0 +1,220 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/mongo"
+	"github.com/airenas/async-api/pkg/rabbit"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testStore struct {
+	recs     []*Record
+	err      error
+	sent     []primitive.ObjectID
+	failed   []primitive.ObjectID
+	parked   []primitive.ObjectID
+	rejected int
+}
+
+func (s *testStore) pending(ctx context.Context, limit int) ([]*Record, error) {
+	if len(s.recs) > limit {
+		return s.recs[:limit], s.err
+	}
+	return s.recs, s.err
+}
+
+func (s *testStore) markSent(ctx context.Context, id primitive.ObjectID) error {
+	s.sent = append(s.sent, id)
+	return nil
+}
+
+func (s *testStore) markFailed(ctx context.Context, id primitive.ObjectID, sendErr error, rejected, park bool) error {
+	s.failed = append(s.failed, id)
+	if rejected {
+		s.rejected++
+	}
+	if park {
+		s.parked = append(s.parked, id)
+	}
+	return nil
+}
+
+type testPublisher struct {
+	keys    []string
+	failOn  int
+	failKey string
+	err     error
+}
+
+func (p *testPublisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	if (p.failOn > 0 && len(p.keys)+1 == p.failOn) || key == p.failKey {
+		if p.err != nil {
+			return p.err
+		}
+		return errors.New("olia")
+	}
+	p.keys = append(p.keys, key)
+	return nil
+}
+
+type testLocker struct {
+	lockErr  error
+	unlocked bool
+}
+
+func (l *testLocker) Lock(ctx context.Context, id string, lockKey string) error {
+	return l.lockErr
+}
+
+func (l *testLocker) KeepAlive(ctx context.Context, id string, lockKey string) <-chan struct{} {
+	res := make(chan struct{})
+	go func() {
+		defer close(res)
+		<-ctx.Done()
+	}()
+	return res
+}
+
+func (l *testLocker) UnLock(ctx context.Context, id string, lockKey string, value *int) error {
+	l.unlocked = true
+	return nil
+}
+
+func TestNewRelay(t *testing.T) {
+	sp, err := mongo.NewSessionProvider("mongodb://localhost:27017", nil, "db")
+	assert.Nil(t, err)
+	locker, err := mongo.NewLocker(sp, "lock")
+	assert.Nil(t, err)
+	newOutbox := func(confirm bool) *Outbox {
+		pr, err := rabbit.NewChannelProviderWithOptions(rabbit.ProviderOptions{URL: "localhost:5672", Confirm: confirm})
+		assert.Nil(t, err)
+		res, err := New(sp, rabbit.NewSender(pr), "outbox")
+		assert.Nil(t, err)
+		return res
+	}
+	tests := []struct {
+		name    string
+		outbox  *Outbox
+		locker  *mongo.Locker
+		opt     RelayOptions
+		wantErr bool
+	}{
+		{name: "OK", outbox: newOutbox(true), locker: locker},
+		{name: "No confirms", outbox: newOutbox(false), locker: locker, wantErr: true},
+		{name: "No outbox", locker: locker, wantErr: true},
+		{name: "No locker", outbox: newOutbox(true), wantErr: true},
+		{name: "Wrong batch", outbox: newOutbox(true), locker: locker, opt: RelayOptions{BatchSize: -1}, wantErr: true},
+		{name: "Wrong attempts", outbox: newOutbox(true), locker: locker, opt: RelayOptions{MaxAttempts: -1},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRelay(tt.outbox, tt.locker, tt.opt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRelay() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, "outbox", got.opt.LockID)
+				assert.Equal(t, 100, got.opt.BatchSize)
+				assert.Equal(t, 10, got.opt.MaxAttempts)
+			}
+		})
+	}
+}
+
+func TestRelay_relay(t *testing.T) {
+	recs := func() []*Record {
+		return []*Record{{ID: primitive.NewObjectID(), Key: "q1"}, {ID: primitive.NewObjectID(), Key: "q2"},
+			{ID: primitive.NewObjectID(), Key: "q3"}}
+	}
+	tests := []struct {
+		name         string
+		lockErr      error
+		storeErr     error
+		failOn       int
+		batch        int
+		want         int
+		wantKeys     []string
+		wantFailed   int
+		wantUnlocked bool
+		wantErr      bool
+	}{
+		{name: "Publishes", want: 3, wantKeys: []string{"q1", "q2", "q3"}, wantUnlocked: true},
+		{name: "Batch", batch: 2, want: 2, wantKeys: []string{"q1", "q2"}, wantUnlocked: true},
+		{name: "Stops on fail", failOn: 2, want: 1, wantKeys: []string{"q1"}, wantFailed: 1, wantUnlocked: true,
+			wantErr: true},
+		{name: "Locked", lockErr: mongo.ErrLocked},
+		{name: "Lock fails", lockErr: errors.New("olia"), wantErr: true},
+		{name: "Store fails", storeErr: errors.New("olia"), wantUnlocked: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &testStore{recs: recs(), err: tt.storeErr}
+			pub := &testPublisher{failOn: tt.failOn}
+			l := &testLocker{lockErr: tt.lockErr}
+			batch := tt.batch
+			if batch == 0 {
+				batch = 100
+			}
+			r := &Relay{store: st, publisher: pub, locker: l,
+				opt: RelayOptions{BatchSize: batch, LockID: "outbox", MaxAttempts: 100}}
+			got, err := r.relay(test.Ctx(t))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Relay.relay() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantKeys, pub.keys)
+			assert.Equal(t, tt.want, len(st.sent))
+			assert.Equal(t, tt.wantFailed, len(st.failed))
+			assert.Equal(t, tt.wantUnlocked, l.unlocked)
+		})
+	}
+}
+
+func TestRelay_relay_ParksPoison(t *testing.T) {
+	poison := &Record{ID: primitive.NewObjectID(), Key: "q1", Attempts: 5, Rejects: 1}
+	st := &testStore{recs: []*Record{poison, {ID: primitive.NewObjectID(), Key: "q2"}}}
+	pub := &testPublisher{failKey: "q1", err: rabbit.ErrNack}
+	r := &Relay{store: st, publisher: pub, locker: &testLocker{},
+		opt: RelayOptions{BatchSize: 100, LockID: "outbox", MaxAttempts: 3}}
+
+	// retried while attempts are left
+	got, err := r.relay(test.Ctx(t))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, got)
+	assert.Empty(t, st.parked)
+	assert.Equal(t, 1, st.rejected)
+
+	poison.Rejects = 2
+	got, err = r.relay(test.Ctx(t))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, got)
+	assert.Equal(t, []primitive.ObjectID{poison.ID}, st.parked)
+	assert.Equal(t, []string{"q2"}, pub.keys)
+}
+
+func TestRelay_relay_TemporaryFailureNotParked(t *testing.T) {
+	for _, sendErr := range []error{rabbit.ErrUnroutable, errors.New("confirm timeout")} {
+		rec := &Record{ID: primitive.NewObjectID(), Key: "q1", Attempts: 1000}
+		st := &testStore{recs: []*Record{rec, {ID: primitive.NewObjectID(), Key: "q2"}}}
+		pub := &testPublisher{failKey: "q1", err: sendErr}
+		r := &Relay{store: st, publisher: pub, locker: &testLocker{},
+			opt: RelayOptions{BatchSize: 100, LockID: "outbox", MaxAttempts: 3}}
+
+		got, err := r.relay(test.Ctx(t))
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, got)
+		assert.Equal(t, 1, len(st.failed))
+		assert.Equal(t, 0, st.rejected)
+		assert.Empty(t, st.parked)
+		assert.Empty(t, pub.keys)
+	}
+}
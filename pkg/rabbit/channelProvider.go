@@ -1,6 +1,10 @@
 package rabbit
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,55 +15,226 @@ import (
 	"github.com/pkg/errors"
 )
 
-//ChannelProvider provider amqp channel
+//ChannelProvider provider amqp channel.
+//It keeps one connection with a pool of publishing channels,
+//a shared channel for declarations and dedicated channels for consumers
 type ChannelProvider struct {
 	url  string
+	cfg  amqp.Config
+	opt  ProviderOptions
 	conn *amqp.Connection
 	ch   *amqp.Channel
-	m    sync.Mutex // struct field mutex
+	// sem is the struct field mutex, waiting for it can be canceled by ctx, see lock
+	sem     chan struct{}
+	semOnce sync.Once
+
+	pool *channelPool
+}
+
+//ProviderOptions keeps ChannelProvider settings
+type ProviderOptions struct {
+	URL, User, Pass string
+	// Confirm turns on publisher confirms: publishing waits for the broker's ack
+	// and fails on nack or on unroutable message
+	Confirm bool
+	// ConfirmTimeout is the max wait for the confirm, defaults to 10s
+	ConfirmTimeout time.Duration
+	// Prefix is a namespace added to all queue, exchange, reply and dead-letter names as <prefix>.<name>,
+	// it allows to run several environments on one vhost
+	Prefix string
+	// PublishChannels is the max number of channels used for publishing in parallel, defaults to 4
+	PublishChannels int
+	// TLS turns on amqps connection, see NewTLSConfig
+	TLS *tls.Config
+	// Vhost overrides the vhost from URL
+	Vhost string
+	// Heartbeat is the connection heartbeat interval, defaults to 10s
+	Heartbeat time.Duration
+	// ConnectionName is shown in the broker's management UI
+	ConnectionName string
+	// ExternalAuth uses EXTERNAL auth mechanism, the user is taken from the TLS client certificate.
+	// Requires TLS, User and Pass are not used
+	ExternalAuth bool
+	// Propagator passes context values in message headers, defaults to HeaderPropagator
+	Propagator Propagator
 }
 
 type runOnChannelFunc func(*amqp.Channel) error
 
 //NewChannelProvider initializes channel provider
 func NewChannelProvider(url, user, pass string) (*ChannelProvider, error) {
-	if url == "" {
+	return NewChannelProviderWithOptions(ProviderOptions{URL: url, User: user, Pass: pass})
+}
+
+//NewChannelProviderWithOptions initializes channel provider with options
+func NewChannelProviderWithOptions(opt ProviderOptions) (*ChannelProvider, error) {
+	if opt.URL == "" {
 		return nil, errors.New("no broker url set")
 	}
-	if user != "" && pass == "" {
+	if opt.ExternalAuth {
+		if opt.TLS == nil {
+			return nil, errors.New("external auth requires TLS")
+		}
+		opt.User, opt.Pass = "", ""
+	}
+	if opt.User != "" && opt.Pass == "" {
 		return nil, errors.New("no broker password set")
 	}
-	return &ChannelProvider{url: prepareURL(url, user, pass)}, nil
+	if opt.Heartbeat < 0 {
+		return nil, errors.Errorf("wrong heartbeat %v", opt.Heartbeat)
+	}
+	if opt.Heartbeat == 0 {
+		opt.Heartbeat = 10 * time.Second
+	}
+	opt.Prefix = strings.Trim(strings.TrimSpace(opt.Prefix), ".")
+	if opt.ConfirmTimeout < 0 {
+		return nil, errors.Errorf("wrong confirm timeout %v", opt.ConfirmTimeout)
+	}
+	if opt.ConfirmTimeout == 0 {
+		opt.ConfirmTimeout = 10 * time.Second
+	}
+	if opt.PublishChannels < 0 {
+		return nil, errors.Errorf("wrong publish channels %d, expected >= 0", opt.PublishChannels)
+	}
+	if opt.PublishChannels == 0 {
+		opt.PublishChannels = 4
+	}
+	res := &ChannelProvider{url: prepareURL(opt.URL, opt.User, opt.Pass), opt: opt,
+		pool: newChannelPool(opt.PublishChannels)}
+	if opt.TLS != nil {
+		res.url = prepareURLWithScheme("amqps", opt.URL, opt.User, opt.Pass)
+	}
+	res.cfg = amqp.Config{Vhost: opt.Vhost, Heartbeat: opt.Heartbeat, TLSClientConfig: opt.TLS, Locale: "en_US"}
+	if opt.ConnectionName != "" {
+		res.cfg.Properties = amqp.Table{"connection_name": opt.ConnectionName}
+	}
+	if opt.ExternalAuth {
+		res.cfg.SASL = []amqp.Authentication{&externalAuth{}}
+	}
+	return res, nil
 }
 
 func prepareURL(url, user, pass string) string {
-	res := "amqp://"
+	return prepareURLWithScheme("amqp", url, user, pass)
+}
+
+func prepareURLWithScheme(scheme, url, user, pass string) string {
+	res := scheme + "://"
 	if user != "" {
 		res += user + ":" + pass + "@"
 	}
 	return res + url
 }
 
-//Channel return cached channel or tries to connect to rabbit broker
+// lock takes the struct field mutex, ctx error is returned if ctx is done while waiting
+func (pr *ChannelProvider) lock(ctx context.Context) error {
+	pr.semOnce.Do(func() { pr.sem = make(chan struct{}, 1) })
+	select {
+	case pr.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pr *ChannelProvider) unlock() {
+	<-pr.sem
+}
+
+//Channel return cached channel or tries to connect to rabbit broker.
+//The channel is shared, use it for declarations, not for publishing or consuming
 func (pr *ChannelProvider) Channel() (*amqp.Channel, error) {
-	pr.m.Lock()
-	defer pr.m.Unlock()
+	ctx := context.Background()
+	_ = pr.lock(ctx)
+	defer pr.unlock()
 
 	if pr.ch != nil {
 		return pr.ch, nil
 	}
-	conn, err := dial(pr.url)
+	ch, err := pr.newChannel(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "can't connect to rabbit broker")
+		return nil, err
+	}
+	pr.ch = ch
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err := <-closed; err != nil {
+			goapp.Log.Warn().Err(err).Msg("rabbit channel closed")
+		}
+		pr.dropChannel(ch)
+	}()
+	return pr.ch, nil
+}
+
+//ConsumerChannel opens a new dedicated channel on the shared connection, caller must close it
+func (pr *ChannelProvider) ConsumerChannel() (*amqp.Channel, error) {
+	return pr.ConsumerChannelContext(context.Background())
+}
+
+//ConsumerChannelContext opens a new dedicated channel, waiting for the connection stops when ctx is done
+func (pr *ChannelProvider) ConsumerChannelContext(ctx context.Context) (*amqp.Channel, error) {
+	if err := pr.lock(ctx); err != nil {
+		return nil, errors.Wrap(err, "can't open channel")
+	}
+	defer pr.unlock()
+
+	return pr.newChannel(ctx)
+}
+
+// newChannel must be called under lock
+func (pr *ChannelProvider) newChannel(ctx context.Context) (*amqp.Channel, error) {
+	if err := pr.connect(ctx); err != nil {
+		return nil, err
 	}
-	ch, err := conn.Channel()
+	ch, err := pr.conn.Channel()
 	if err != nil {
-		defer conn.Close()
 		return nil, errors.Wrap(err, "can't create channel")
 	}
+	return ch, nil
+}
+
+// connect dials the broker if there is no live connection, must be called under lock
+func (pr *ChannelProvider) connect(ctx context.Context) error {
+	if pr.conn != nil && !pr.conn.IsClosed() {
+		return nil
+	}
+	pr.ch = nil
+	pr.conn = nil
+	conn, err := dial(ctx, pr.url, pr.cfg)
+	if err != nil {
+		return errors.Wrap(err, "can't connect to rabbit broker")
+	}
 	pr.conn = conn
-	pr.ch = ch
-	return pr.ch, nil
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err := <-closed; err != nil {
+			goapp.Log.Warn().Err(err).Msg("rabbit connection closed")
+		}
+		pr.dropConnection(conn)
+	}()
+	return nil
+}
+
+// dropConnection forgets closed connection, so the next call reconnects without failing
+func (pr *ChannelProvider) dropConnection(conn *amqp.Connection) {
+	_ = pr.lock(context.Background())
+	defer pr.unlock()
+
+	if pr.conn == conn {
+		pr.conn = nil
+		pr.ch = nil
+	}
+}
+
+// dropChannel forgets the shared channel, it is closed if still open
+func (pr *ChannelProvider) dropChannel(ch *amqp.Channel) {
+	_ = pr.lock(context.Background())
+	defer pr.unlock()
+
+	if pr.ch == ch {
+		_ = pr.ch.Close()
+		pr.ch = nil
+	}
 }
 
 //RunOnChannelWithRetry invokes method on channel with retry
@@ -71,7 +246,7 @@ func (pr *ChannelProvider) RunOnChannelWithRetry(f runOnChannelFunc) error {
 	err = f(ch)
 	if err != nil {
 		goapp.Log.Info().Msgf("retry opening channel")
-		pr.Close()
+		pr.dropChannel(ch)
 		ch, err = pr.Channel()
 		if err != nil {
 			return errors.Wrap(err, "can't init channel")
@@ -81,10 +256,49 @@ func (pr *ChannelProvider) RunOnChannelWithRetry(f runOnChannelFunc) error {
 	return err
 }
 
+//Publish publishes the message on a pooled channel. In confirm mode it waits for the broker's confirm
+func (pr *ChannelProvider) Publish(exchange, key string, msg amqp.Publishing) error {
+	if pr.pool == nil {
+		return errors.New("channel provider is not initialized")
+	}
+	err := pr.publish(exchange, key, msg)
+	if err != nil && !errors.Is(err, ErrNack) && !errors.Is(err, ErrUnroutable) {
+		goapp.Log.Info().Msgf("retry publishing")
+		err = pr.publish(exchange, key, msg)
+	}
+	return err
+}
+
+func (pr *ChannelProvider) publish(exchange, key string, msg amqp.Publishing) error {
+	pc, err := pr.pool.get(pr.openPubChannel)
+	if err != nil {
+		return errors.Wrap(err, "can't init channel")
+	}
+	defer pr.pool.put(pc)
+	return pc.publish(exchange, key, msg)
+}
+
+func (pr *ChannelProvider) openPubChannel() (*pubChannel, error) {
+	ch, err := pr.ConsumerChannel()
+	if err != nil {
+		return nil, err
+	}
+	pc, err := newPubChannel(ch, pr.opt.Confirm, pr.opt.ConfirmTimeout)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
 //Close finalizes ChannelProvider
 func (pr *ChannelProvider) Close() {
-	pr.m.Lock()
-	defer pr.m.Unlock()
+	if pr.pool != nil {
+		pr.pool.closeIdle()
+	}
+
+	_ = pr.lock(context.Background())
+	defer pr.unlock()
 
 	if pr.ch != nil {
 		_ = pr.ch.Close()
@@ -96,9 +310,27 @@ func (pr *ChannelProvider) Close() {
 	pr.conn = nil
 }
 
-//QueueName return queue name for channel, may append prefix
+//QueueName return queue name for channel, may append prefix.
+//Empty (default exchange), broker reserved amq.* and already prefixed names are not changed
 func (pr *ChannelProvider) QueueName(name string) string {
-	return name
+	if pr.opt.Prefix == "" || name == "" || strings.HasPrefix(name, "amq.") ||
+		strings.HasPrefix(name, pr.opt.Prefix+".") {
+		return name
+	}
+	return pr.opt.Prefix + "." + name
+}
+
+//Confirms returns true if publisher confirms are on, see ProviderOptions.Confirm
+func (pr *ChannelProvider) Confirms() bool {
+	return pr.opt.Confirm
+}
+
+// propagator returns configured Propagator or the default one
+func (pr *ChannelProvider) propagator() Propagator {
+	if pr.opt.Propagator == nil {
+		return HeaderPropagator{}
+	}
+	return pr.opt.Propagator
 }
 
 // Healthy checks if rabbit channel is open
@@ -110,19 +342,47 @@ func (pr *ChannelProvider) Healthy() error {
 	return nil
 }
 
-func dial(url string) (*amqp.Connection, error) {
+// dial retries connecting for up to 2 minutes, it stops when ctx is done
+func dial(ctx context.Context, url string, cfg amqp.Config) (*amqp.Connection, error) {
 	var res *amqp.Connection
 	op := func() error {
 		var err error
 		goapp.Log.Info().Msg("Dial " + goapp.HidePass(url))
-		res, err = amqp.Dial(url)
+		c := cfg
+		if c.TLSClientConfig != nil {
+			// the lib modifies the config
+			c.TLSClientConfig = c.TLSClientConfig.Clone()
+		}
+		if c.Dial == nil {
+			c.Dial = dialContext(ctx, dialTimeout)
+		}
+		res, err = amqp.DialConfig(url, c)
 		return err
 	}
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxElapsedTime = 2 * time.Minute
-	err := backoff.Retry(op, bo)
+	err := backoff.Retry(op, backoff.WithContext(bo, ctx))
 	if err == nil {
 		goapp.Log.Info().Msg("Connected to " + goapp.HidePass(url))
 	}
 	return res, err
 }
+
+const dialTimeout = 30 * time.Second
+
+// dialContext is amqp.DefaultDial canceled by ctx
+func dialContext(ctx context.Context, timeout time.Duration) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		d := net.Dialer{Timeout: timeout}
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// the deadline for TLS and AMQP handshaking, amqp clears it after the handshake
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -1,8 +1,13 @@
 package rabbit
 
 import (
+	"context"
+	"crypto/tls"
 	"testing"
+	"time"
 
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,6 +21,27 @@ func TestNoPrefix(t *testing.T) {
 	assert.Equal(t, "olia", prv.QueueName("olia"))
 }
 
+func TestPrefix(t *testing.T) {
+	prv, err := NewChannelProviderWithOptions(ProviderOptions{URL: "url", Prefix: " stg. "})
+	assert.Nil(t, err)
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{name: "Empty", args: "", want: ""},
+		{name: "Prefix", args: "olia", want: "stg.olia"},
+		{name: "Dead-letter", args: "olia.dlq", want: "stg.olia.dlq"},
+		{name: "Reserved", args: "amq.rabbitmq.reply-to", want: "amq.rabbitmq.reply-to"},
+		{name: "Already", args: "stg.olia", want: "stg.olia"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, prv.QueueName(tt.args))
+		})
+	}
+}
+
 func TestNewChannelProvider(t *testing.T) {
 	type args struct {
 		url  string
@@ -46,6 +72,41 @@ func TestNewChannelProvider(t *testing.T) {
 	}
 }
 
+func TestNewChannelProviderWithOptions(t *testing.T) {
+	got, err := NewChannelProviderWithOptions(ProviderOptions{URL: "url", Confirm: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, got.opt.ConfirmTimeout)
+	assert.Equal(t, 4, got.opt.PublishChannels)
+	got, err = NewChannelProviderWithOptions(ProviderOptions{URL: "url", Confirm: true, ConfirmTimeout: time.Second})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, got.opt.ConfirmTimeout)
+	_, err = NewChannelProviderWithOptions(ProviderOptions{URL: "url", ConfirmTimeout: -time.Second})
+	assert.NotNil(t, err)
+}
+
+func TestNewChannelProviderWithOptions_TLS(t *testing.T) {
+	got, err := NewChannelProviderWithOptions(ProviderOptions{URL: "host:5671/vh", User: "u", Pass: "p",
+		TLS: &tls.Config{}, ConnectionName: "olia"})
+	assert.Nil(t, err)
+	assert.Equal(t, "amqps://u:p@host:5671/vh", got.url)
+	assert.Equal(t, "olia", got.cfg.Properties["connection_name"])
+	assert.Equal(t, 10*time.Second, got.cfg.Heartbeat)
+	assert.Nil(t, got.cfg.SASL)
+
+	got, err = NewChannelProviderWithOptions(ProviderOptions{URL: "host", User: "u", TLS: &tls.Config{},
+		ExternalAuth: true, Vhost: "vh", Heartbeat: time.Minute})
+	assert.Nil(t, err)
+	assert.Equal(t, "amqps://host", got.url)
+	assert.Equal(t, "vh", got.cfg.Vhost)
+	assert.Equal(t, time.Minute, got.cfg.Heartbeat)
+	if assert.Equal(t, 1, len(got.cfg.SASL)) {
+		assert.Equal(t, "EXTERNAL", got.cfg.SASL[0].Mechanism())
+	}
+
+	_, err = NewChannelProviderWithOptions(ProviderOptions{URL: "host", ExternalAuth: true})
+	assert.NotNil(t, err)
+}
+
 func Test_prepareURL(t *testing.T) {
 	type args struct {
 		url  string
@@ -68,3 +129,22 @@ func Test_prepareURL(t *testing.T) {
 		})
 	}
 }
+
+func Test_dial_Canceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(test.Ctx(t), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := dial(ctx, "amqp://localhost:1", amqp.Config{})
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestChannelProvider_ConsumerChannelContext_Locked(t *testing.T) {
+	pr := &ChannelProvider{}
+	assert.Nil(t, pr.lock(test.Ctx(t)))
+	defer pr.unlock()
+	ctx, cancel := context.WithTimeout(test.Ctx(t), 50*time.Millisecond)
+	defer cancel()
+	_, err := pr.ConsumerChannelContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,159 @@
+package rabbit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/airenas/async-api/pkg/api"
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// HeaderClaimCheck keeps the name of the body saved in ClaimStore, the message itself has no body
+const HeaderClaimCheck = "x-claim-check"
+
+// ClaimStore keeps large message bodies, see SenderOptions.ClaimCheckSize.
+// A body is deleted by the consumer after the message is processed and acked,
+// so Load must return an error wrapping fs.ErrNotExist for a deleted body: a redelivered copy is dropped then.
+// Dead-lettered messages keep their bodies for the redrive, clean them by the ClaimCheckDir prefix
+type ClaimStore interface {
+	Save(ctx context.Context, name string, data []byte) error
+	Load(ctx context.Context, name string) ([]byte, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// ClaimCheckDir is the name prefix of all bodies saved in ClaimStore
+const ClaimCheckDir = "claim-check/"
+
+// FileStorage is a remote file storage, implemented by miniofs.Filer
+type FileStorage interface {
+	SaveFile(ctx context.Context, name string, reader io.Reader, fileSize int64) error
+	LoadFile(ctx context.Context, name string) (io.ReadSeekCloser, error)
+	DeleteFile(ctx context.Context, name string) error
+}
+
+// FileSaver saves and deletes files, implemented by file.LocalSaver
+type FileSaver interface {
+	Save(name string, reader io.Reader) error
+	Delete(name string) error
+}
+
+// FileLoader loads files, implemented by file.LocalLoader
+type FileLoader interface {
+	Load(name string) (api.FileRead, error)
+}
+
+type fileStorageClaimStore struct {
+	fs FileStorage
+}
+
+// NewFileStorageClaimStore creates ClaimStore on miniofs.Filer
+func NewFileStorageClaimStore(fs FileStorage) ClaimStore {
+	return &fileStorageClaimStore{fs: fs}
+}
+
+func (s *fileStorageClaimStore) Save(ctx context.Context, name string, data []byte) error {
+	return s.fs.SaveFile(ctx, name, bytes.NewReader(data), int64(len(data)))
+}
+
+func (s *fileStorageClaimStore) Load(ctx context.Context, name string) ([]byte, error) {
+	f, err := s.fs.LoadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *fileStorageClaimStore) Delete(ctx context.Context, name string) error {
+	return s.fs.DeleteFile(ctx, name)
+}
+
+type localClaimStore struct {
+	saver  FileSaver
+	loader FileLoader
+}
+
+// NewLocalClaimStore creates ClaimStore on local (shared) disk,
+// both saver and loader must point to the same directory
+func NewLocalClaimStore(saver FileSaver, loader FileLoader) ClaimStore {
+	return &localClaimStore{saver: saver, loader: loader}
+}
+
+func (s *localClaimStore) Save(ctx context.Context, name string, data []byte) error {
+	return s.saver.Save(name, bytes.NewReader(data))
+}
+
+func (s *localClaimStore) Load(ctx context.Context, name string) ([]byte, error) {
+	f, err := s.loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *localClaimStore) Delete(ctx context.Context, name string) error {
+	return s.saver.Delete(name)
+}
+
+// checkIn saves the body into the store and leaves only the reference in the message
+func checkIn(ctx context.Context, store ClaimStore, msg *amqp.Publishing) error {
+	name := ClaimCheckDir + uuid.NewString()
+	if err := store.Save(ctx, name, msg.Body); err != nil {
+		return errors.Wrap(err, "can't save claim check")
+	}
+	msg.Headers[HeaderClaimCheck] = name
+	msg.Body = nil
+	return nil
+}
+
+// errClaimNotFound is returned for a deleted claim check, i.e. for a copy of the already processed message
+var errClaimNotFound = errors.New("claim check not found")
+
+// readBody returns decompressed message body, loads it from store if the message has a claim check.
+// *claimLoadError is returned if the store fails, so the message can be retried
+func readBody(ctx context.Context, store ClaimStore, d amqp.Delivery) ([]byte, error) {
+	body := d.Body
+	if name, ok := d.Headers[HeaderClaimCheck].(string); ok {
+		if store == nil {
+			return nil, errors.Errorf("no claim store to load %s", name)
+		}
+		var err error
+		if body, err = store.Load(ctx, name); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, errors.Wrapf(errClaimNotFound, "%s: %v", name, err)
+			}
+			return nil, &claimLoadError{err: errors.Wrapf(err, "can't load claim check %s", name)}
+		}
+	}
+	return decompress(d.ContentEncoding, body)
+}
+
+// checkOut deletes the message's body from store after the message is processed,
+// the failure is only logged as the message is already acked
+func checkOut(ctx context.Context, store ClaimStore, d amqp.Delivery) {
+	name, ok := d.Headers[HeaderClaimCheck].(string)
+	if !ok || store == nil {
+		return
+	}
+	if err := store.Delete(ctx, name); err != nil {
+		goapp.Log.Warn().Err(err).Str("claim", name).Msg("can't delete claim check")
+	}
+}
+
+type claimLoadError struct {
+	err error
+}
+
+func (e *claimLoadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *claimLoadError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,206 @@
+package rabbit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/api"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readBody_Fails(t *testing.T) {
+	d := amqp.Delivery{Headers: amqp.Table{HeaderClaimCheck: "claim-check/1"}}
+	_, err := readBody(test.Ctx(t), nil, d)
+	assert.NotNil(t, err)
+	var le *claimLoadError
+	assert.False(t, errors.As(err, &le))
+
+	_, err = readBody(test.Ctx(t), &testClaimStore{err: errors.New("olia")}, d)
+	assert.True(t, errors.As(err, &le))
+}
+
+func TestConsumer_process_ClaimCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		storeErr    error
+		handlerErr  error
+		wantCalled  bool
+		wantAck     int
+		wantNack    int
+		wantRequeue bool
+		wantDeleted bool
+	}{
+		{name: "OK", wantCalled: true, wantAck: 1, wantDeleted: true},
+		{name: "Store fails", storeErr: errors.New("olia"), wantNack: 1, wantRequeue: true},
+		{name: "Handler fails", handlerErr: errors.New("olia"), wantCalled: true, wantNack: 1, wantRequeue: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			store := &testClaimStore{data: map[string][]byte{"claim-check/1": []byte(`{"id":"1"}`)}, err: tt.storeErr}
+			c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q", ClaimStore: store},
+				func(ctx context.Context, msg *messages.QueueMessage) error {
+					called = true
+					assert.Equal(t, "1", msg.ID)
+					return tt.handlerErr
+				})
+			ack := &testAcknowledger{}
+			c.process(test.Ctx(t), &testPublisher{}, amqp.Delivery{Acknowledger: ack,
+				Headers: amqp.Table{HeaderClaimCheck: "claim-check/1"}})
+			assert.Equal(t, tt.wantCalled, called)
+			assert.Equal(t, tt.wantAck, ack.acks)
+			assert.Equal(t, tt.wantNack, ack.nacks)
+			assert.Equal(t, tt.wantRequeue, ack.requeue)
+			_, ok := store.data["claim-check/1"]
+			assert.Equal(t, tt.wantDeleted, !ok)
+		})
+	}
+}
+
+func TestConsumer_process_ClaimCheckRedelivered(t *testing.T) {
+	for _, max := range []int{0, 3} {
+		store := &testClaimStore{data: map[string][]byte{"claim-check/1": []byte(`{"id":"1"}`)}}
+		called := 0
+		c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q", ClaimStore: store, MaxDeliveries: max},
+			func(ctx context.Context, msg *messages.QueueMessage) error {
+				called++
+				return nil
+			})
+		ack := &testAcknowledger{}
+		pub := &testPublisher{}
+		d := amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{HeaderClaimCheck: "claim-check/1"}}
+		c.process(test.Ctx(t), pub, d)
+		// at least once delivery: the copy arrives after the body is deleted
+		c.process(test.Ctx(t), pub, d)
+		assert.Equal(t, 1, called)
+		assert.Equal(t, 2, ack.acks)
+		assert.Equal(t, 0, ack.nacks)
+		assert.Empty(t, pub.msgs)
+	}
+}
+
+func Test_readBody_ClaimNotFound(t *testing.T) {
+	d := amqp.Delivery{Headers: amqp.Table{HeaderClaimCheck: "claim-check/1"}}
+	_, err := readBody(test.Ctx(t), &testClaimStore{data: map[string][]byte{}}, d)
+	assert.True(t, errors.Is(err, errClaimNotFound))
+	var le *claimLoadError
+	assert.False(t, errors.As(err, &le))
+}
+
+func TestConsumer_process_ClaimCheckKeptOnDeadLetter(t *testing.T) {
+	store := &testClaimStore{data: map[string][]byte{"claim-check/1": []byte(`{"id":"1"}`)}}
+	c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q", ClaimStore: store, MaxDeliveries: 1},
+		func(ctx context.Context, msg *messages.QueueMessage) error {
+			return errors.New("olia")
+		})
+	ack := &testAcknowledger{}
+	pub := &testPublisher{}
+	c.process(test.Ctx(t), pub, amqp.Delivery{Acknowledger: ack,
+		Headers: amqp.Table{HeaderClaimCheck: "claim-check/1"}})
+	assert.Equal(t, 1, ack.acks)
+	assert.Contains(t, store.data, "claim-check/1")
+}
+
+func TestRPCClient_decodeReply_ClaimCheck(t *testing.T) {
+	store := &testClaimStore{data: map[string][]byte{"claim-check/1": []byte(`{"id":"1"}`)}}
+	c, _ := NewRPCClientWithOptions(&ChannelProvider{}, RPCClientOptions{Timeout: time.Second, ClaimStore: store})
+	got, err := c.decodeReply(test.Ctx(t), amqp.Delivery{Headers: amqp.Table{HeaderClaimCheck: "claim-check/1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", got.ID)
+	assert.Empty(t, store.data)
+}
+
+func TestNewFileStorageClaimStore(t *testing.T) {
+	fs := &testFileStorage{}
+	s := NewFileStorageClaimStore(fs)
+	assert.Nil(t, s.Save(test.Ctx(t), "f", []byte("olia")))
+	assert.Equal(t, int64(4), fs.size)
+	got, err := s.Load(test.Ctx(t), "f")
+	assert.Nil(t, err)
+	assert.Equal(t, "olia", string(got))
+	assert.Nil(t, s.Delete(test.Ctx(t), "f"))
+	assert.Nil(t, fs.data)
+}
+
+func TestNewLocalClaimStore(t *testing.T) {
+	fs := &testFileStorage{}
+	s := NewLocalClaimStore(fs, fs)
+	assert.Nil(t, s.Save(test.Ctx(t), "f", []byte("olia")))
+	got, err := s.Load(test.Ctx(t), "f")
+	assert.Nil(t, err)
+	assert.Equal(t, "olia", string(got))
+	assert.Nil(t, s.Delete(test.Ctx(t), "f"))
+	assert.Nil(t, fs.data)
+}
+
+type testClaimStore struct {
+	data map[string][]byte
+	err  error
+}
+
+func (s *testClaimStore) Save(ctx context.Context, name string, data []byte) error {
+	s.data[name] = data
+	return s.err
+}
+
+func (s *testClaimStore) Load(ctx context.Context, name string) ([]byte, error) {
+	res, ok := s.data[name]
+	if !ok && s.err == nil {
+		return nil, fs.ErrNotExist
+	}
+	return res, s.err
+}
+
+func (s *testClaimStore) Delete(ctx context.Context, name string) error {
+	delete(s.data, name)
+	return s.err
+}
+
+type testFileStorage struct {
+	data []byte
+	size int64
+}
+
+func (fs *testFileStorage) SaveFile(ctx context.Context, name string, reader io.Reader, fileSize int64) error {
+	fs.size = fileSize
+	return fs.Save(name, reader)
+}
+
+func (fs *testFileStorage) LoadFile(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	return fs.Load(name)
+}
+
+func (fs *testFileStorage) DeleteFile(ctx context.Context, name string) error {
+	return fs.Delete(name)
+}
+
+func (fs *testFileStorage) Delete(name string) error {
+	fs.data = nil
+	return nil
+}
+
+func (fs *testFileStorage) Save(name string, reader io.Reader) error {
+	var err error
+	fs.data, err = io.ReadAll(reader)
+	return err
+}
+
+func (fs *testFileStorage) Load(name string) (api.FileRead, error) {
+	return &testFile{Reader: bytes.NewReader(fs.data)}, nil
+}
+
+type testFile struct {
+	*bytes.Reader
+}
+
+func (f *testFile) Close() error               { return nil }
+func (f *testFile) Stat() (os.FileInfo, error) { return nil, nil }
@@ -0,0 +1,137 @@
+package rabbit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes message bodies, the codec's content type is passed as message's ContentType,
+// so the consumer selects the codec by it
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+const (
+	// ContentTypeJSON is the content type of JSONCodec
+	ContentTypeJSON = "application/json"
+	// ContentTypeMsgPack is the content type of MsgPackCodec
+	ContentTypeMsgPack = "application/msgpack"
+	// ContentTypeProtobuf is the content type of ProtobufCodec
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// JSONCodec encodes messages to JSON, it is the default codec
+type JSONCodec struct{}
+
+// ContentType implements Codec
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+// Marshal implements Codec
+func (JSONCodec) Marshal(v any) ([]byte, error) { return getBytes(v) }
+
+// Unmarshal implements Codec
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// MsgPackCodec encodes messages to MessagePack, field names are taken from json tags
+type MsgPackCodec struct{}
+
+// ContentType implements Codec
+func (MsgPackCodec) ContentType() string { return ContentTypeMsgPack }
+
+// Marshal implements Codec
+func (MsgPackCodec) Marshal(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := msgpack.NewEncoder(&b)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, errors.Wrap(err, "can't marshal msgpack")
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal implements Codec
+func (MsgPackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// ProtobufCodec encodes protobuf messages, values must implement proto.Message.
+// Add GetID method to the generated type to send it with Sender
+type ProtobufCodec struct{}
+
+// ContentType implements Codec
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+// Marshal implements Codec
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T is not proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements Codec
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.Errorf("%T is not proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// codecFor selects the codec by the message's content type, custom codecs are checked first.
+// Messages without content type are treated as JSON
+func codecFor(contentType string, custom []Codec) (Codec, error) {
+	ct := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	for _, c := range custom {
+		if c.ContentType() == ct {
+			return c, nil
+		}
+	}
+	switch ct {
+	case "", ContentTypeJSON, "text/json":
+		return JSONCodec{}, nil
+	case ContentTypeMsgPack, "application/x-msgpack":
+		return MsgPackCodec{}, nil
+	case ContentTypeProtobuf, "application/protobuf":
+		return ProtobufCodec{}, nil
+	}
+	return nil, errors.Errorf("no codec for content type '%s'", contentType)
+}
+
+// codecs selects the codec by the queue or exchange name
+type codecs struct {
+	def      Codec
+	byTarget map[string]Codec
+}
+
+func (c codecs) get(name string) Codec {
+	if res, ok := c.byTarget[name]; ok {
+		return res
+	}
+	if c.def != nil {
+		return c.def
+	}
+	return JSONCodec{}
+}
+
+func (c codecs) onlyJSON() bool {
+	if c.get("").ContentType() != ContentTypeJSON {
+		return false
+	}
+	for _, v := range c.byTarget {
+		if v.ContentType() != ContentTypeJSON {
+			return false
+		}
+	}
+	return true
+}
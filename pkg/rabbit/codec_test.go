@@ -0,0 +1,95 @@
+package rabbit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodecs(t *testing.T) {
+	at := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := &messages.InformMessage{QueueMessage: messages.QueueMessage{ID: "1",
+		Tags: []messages.Tag{{Key: "k", Value: "v"}}}, Type: messages.InformTypeStarted, At: at}
+	for _, c := range []Codec{JSONCodec{}, MsgPackCodec{}} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			b, err := c.Marshal(msg)
+			assert.Nil(t, err)
+			var got messages.InformMessage
+			assert.Nil(t, c.Unmarshal(b, &got))
+			got.At = got.At.UTC()
+			assert.Equal(t, msg, &got)
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c := ProtobufCodec{}
+	b, err := c.Marshal(wrapperspb.String("olia"))
+	assert.Nil(t, err)
+	var got wrapperspb.StringValue
+	assert.Nil(t, c.Unmarshal(b, &got))
+	assert.Equal(t, "olia", got.GetValue())
+
+	_, err = c.Marshal(&messages.QueueMessage{})
+	assert.NotNil(t, err)
+	assert.NotNil(t, c.Unmarshal(b, &messages.QueueMessage{}))
+}
+
+func Test_codecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		custom      []Codec
+		want        Codec
+		wantErr     bool
+	}{
+		{contentType: "", want: JSONCodec{}},
+		{contentType: "application/json; charset=utf-8", want: JSONCodec{}},
+		{contentType: ContentTypeMsgPack, want: MsgPackCodec{}},
+		{contentType: "application/x-msgpack", want: MsgPackCodec{}},
+		{contentType: ContentTypeProtobuf, want: ProtobufCodec{}},
+		{contentType: "application/olia", custom: []Codec{testCodec{}}, want: testCodec{}},
+		{contentType: "application/olia", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			got, err := codecFor(tt.contentType, tt.custom)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConsumer_process_Codec(t *testing.T) {
+	b, _ := MsgPackCodec{}.Marshal(&messages.QueueMessage{ID: "1"})
+	called := false
+	c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q"},
+		func(ctx context.Context, msg *messages.QueueMessage) error {
+			called = true
+			assert.Equal(t, "1", msg.ID)
+			return nil
+		})
+	ack := &testAcknowledger{}
+	c.process(test.Ctx(t), &testPublisher{}, amqp.Delivery{Acknowledger: ack, ContentType: ContentTypeMsgPack, Body: b})
+	assert.True(t, called)
+	assert.Equal(t, 1, ack.acks)
+
+	pc, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q"},
+		func(ctx context.Context, msg *wrapperspb.StringValue) error {
+			assert.Equal(t, "olia", msg.GetValue())
+			return nil
+		})
+	b, _ = ProtobufCodec{}.Marshal(wrapperspb.String("olia"))
+	ack = &testAcknowledger{}
+	pc.process(test.Ctx(t), &testPublisher{}, amqp.Delivery{Acknowledger: ack, ContentType: ContentTypeProtobuf, Body: b})
+	assert.Equal(t, 1, ack.acks)
+}
+
+type testCodec struct{ JSONCodec }
+
+func (testCodec) ContentType() string { return "application/olia" }
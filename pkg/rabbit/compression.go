@@ -0,0 +1,91 @@
+package rabbit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression is the body compression, the value is passed as ContentEncoding
+type Compression string
+
+const (
+	// CompressionNone sends the body as is
+	CompressionNone Compression = ""
+	// CompressionGzip compresses the body with gzip
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses the body with zstd
+	CompressionZstd Compression = "zstd"
+)
+
+// zstd encoder and decoder are safe for concurrent EncodeAll/DecodeAll
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+func (c Compression) validate() error {
+	switch c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return errors.Errorf("unknown compression '%s'", c)
+}
+
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, errors.Wrap(err, "can't gzip")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "can't gzip")
+		}
+		return b.Bytes(), nil
+	case CompressionZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, errors.Wrap(err, "can't init zstd")
+		}
+		return enc.EncodeAll(data, nil), nil
+	}
+	return nil, errors.Errorf("unknown compression '%s'", c)
+}
+
+// decompress decodes the body by the message's ContentEncoding
+func decompress(encoding string, data []byte) ([]byte, error) {
+	switch Compression(encoding) {
+	case CompressionNone, "identity":
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "can't gunzip")
+		}
+		defer r.Close()
+		res, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't gunzip")
+		}
+		return res, nil
+	case CompressionZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, errors.Wrap(err, "can't init zstd")
+		}
+		res, err := dec.DecodeAll(data, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't decode zstd")
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("unknown content encoding '%s'", encoding)
+}
@@ -0,0 +1,38 @@
+package rabbit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compress(t *testing.T) {
+	data := []byte(strings.Repeat(`{"id":"olia"}`, 100))
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(string(c), func(t *testing.T) {
+			got, err := compress(c, data)
+			assert.Nil(t, err)
+			if c != CompressionNone {
+				assert.Less(t, len(got), len(data))
+			}
+			got, err = decompress(string(c), got)
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+func Test_compress_Fails(t *testing.T) {
+	_, err := compress("olia", []byte("{}"))
+	assert.NotNil(t, err)
+	_, err = decompress("olia", []byte("{}"))
+	assert.NotNil(t, err)
+	_, err = decompress("gzip", []byte("{}"))
+	assert.NotNil(t, err)
+	_, err = decompress("zstd", []byte("{}"))
+	assert.NotNil(t, err)
+	got, err := decompress("identity", []byte("{}"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(got))
+}
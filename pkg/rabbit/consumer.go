@@ -0,0 +1,364 @@
+package rabbit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// HandlerFunc processes one decoded queue message
+type HandlerFunc[T any] func(ctx context.Context, msg *T) error
+
+// ErrRetryLater is wrapped by handler errors to postpone the message, e.g. when it is being processed elsewhere.
+// The message is redelivered after RetryDelay (defaults to 5s if not set), the attempt is not counted
+var ErrRetryLater = errors.New("retry later")
+
+const defaultRetryLaterDelay = 5 * time.Second
+
+// ConsumerOptions keeps consumer settings
+type ConsumerOptions struct {
+	// Queue to listen, QueueName of the provider is applied
+	Queue string
+	// Prefetch is the number of unacknowledged messages the broker sends in advance, defaults to Workers
+	Prefetch int
+	// Workers is the number of messages processed in parallel, defaults to 1
+	Workers int
+	// MaxDeliveries is the number of processing attempts before the message is moved to the dead-letter queue.
+	// If 0 - failed messages are requeued forever and malformed ones are dropped.
+	// The queue must be declared with QueueOptions.DeadLetter
+	MaxDeliveries int
+	// RetryDelay is the delay before the first redelivery of a failed message, it is doubled on each next attempt.
+	// If 0 - the message is redelivered immediately. Requires MaxDeliveries
+	RetryDelay time.Duration
+	// MaxRetryDelay limits the redelivery delay, no limit if 0
+	MaxRetryDelay time.Duration
+	// Registry decodes and validates versioned envelopes, see NewSenderWithRegistry.
+	// If set, messages without envelope or of other types are treated as malformed
+	Registry *messages.Registry
+	// ClaimStore loads bodies of claim-checked messages, see SenderOptions.ClaimStore
+	ClaimStore ClaimStore
+	// Codecs adds custom codecs, the codec is selected by message's ContentType.
+	// JSON, MessagePack and protobuf are supported by default
+	Codecs []Codec
+}
+
+// DeliveryInfo keeps info about the message delivery, it is passed to the handler in context
+type DeliveryInfo struct {
+	// Attempt is the current processing attempt, starts from 1
+	Attempt int
+	// MaxAttempts is the number of allowed attempts, 0 - unlimited
+	MaxAttempts int
+	// ReplyTo and CorrelationID are set for requests sent by RPCClient, see Sender.Reply
+	ReplyTo       string
+	CorrelationID string
+	// Exchange and RoutingKey the message was published with, see Publisher.PublishEvent
+	Exchange   string
+	RoutingKey string
+}
+
+// RetriesLeft returns how many more attempts will be made if the current one fails, -1 - unlimited
+func (di DeliveryInfo) RetriesLeft() int {
+	if di.MaxAttempts == 0 {
+		return -1
+	}
+	if di.Attempt >= di.MaxAttempts {
+		return 0
+	}
+	return di.MaxAttempts - di.Attempt
+}
+
+type deliveryInfoKey struct{}
+
+// DeliveryInfoFromContext returns delivery info set by Consumer
+func DeliveryInfoFromContext(ctx context.Context) (DeliveryInfo, bool) {
+	res, ok := ctx.Value(deliveryInfoKey{}).(DeliveryInfo)
+	return res, ok
+}
+
+// Consumer listens to the queue and passes decoded messages to the handler.
+// It reopens the channel when it is closed and drains in-flight messages on stop
+type Consumer[T any] struct {
+	provider      *ChannelProvider
+	opt           ConsumerOptions
+	queue         string
+	handler       HandlerFunc[T]
+	reconnectWait time.Duration
+	delays        delayQueues
+}
+
+// NewConsumer creates consumer instance
+func NewConsumer[T any](provider *ChannelProvider, opt ConsumerOptions, handler HandlerFunc[T]) (*Consumer[T], error) {
+	if provider == nil {
+		return nil, errors.New("no channel provider")
+	}
+	if opt.Queue == "" {
+		return nil, errors.New("no queue")
+	}
+	if handler == nil {
+		return nil, errors.New("no handler")
+	}
+	if opt.Workers < 0 || opt.Prefetch < 0 {
+		return nil, errors.Errorf("wrong workers %d or prefetch %d, expected >= 0", opt.Workers, opt.Prefetch)
+	}
+	if opt.MaxDeliveries < 0 {
+		return nil, errors.Errorf("wrong max deliveries %d, expected >= 0", opt.MaxDeliveries)
+	}
+	if opt.RetryDelay < 0 || opt.MaxRetryDelay < 0 {
+		return nil, errors.Errorf("wrong retry delay %v or max retry delay %v", opt.RetryDelay, opt.MaxRetryDelay)
+	}
+	if opt.RetryDelay > 0 && opt.MaxDeliveries == 0 {
+		return nil, errors.New("retry delay requires max deliveries")
+	}
+	if opt.Workers == 0 {
+		opt.Workers = 1
+	}
+	if opt.Prefetch == 0 {
+		opt.Prefetch = opt.Workers
+	}
+	return &Consumer[T]{provider: provider, opt: opt, queue: provider.QueueName(opt.Queue), handler: handler,
+		reconnectWait: 5 * time.Second}, nil
+}
+
+// Start starts consuming in background, the returned channel is closed when the consumer stops after ctx is canceled
+func (c *Consumer[T]) Start(ctx context.Context) <-chan struct{} {
+	goapp.Log.Info().Str("queue", c.opt.Queue).Int("workers", c.opt.Workers).Int("prefetch", c.opt.Prefetch).
+		Msg("Starting consumer")
+	res := make(chan struct{}, 2)
+	go func() {
+		defer close(res)
+		c.run(ctx)
+	}()
+	return res
+}
+
+func (c *Consumer[T]) run(ctx context.Context) {
+	for {
+		err := c.consume(ctx)
+		if ctx.Err() != nil {
+			goapp.Log.Info().Str("queue", c.opt.Queue).Msg("Stopped consumer")
+			return
+		}
+		goapp.Log.Error().Err(err).Str("queue", c.opt.Queue).Msgf("Consumer interrupted, retry in %v", c.reconnectWait)
+		select {
+		case <-ctx.Done():
+			goapp.Log.Info().Str("queue", c.opt.Queue).Msg("Stopped consumer")
+			return
+		case <-time.After(c.reconnectWait):
+		}
+	}
+}
+
+func (c *Consumer[T]) consume(ctx context.Context) error {
+	ch, err := c.provider.ConsumerChannelContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't open channel")
+	}
+	defer ch.Close()
+
+	if err := ch.Qos(c.opt.Prefetch, 0, false); err != nil {
+		return errors.Wrap(err, "can't set prefetch")
+	}
+	tag := uuid.NewString()
+	deliveries, err := ch.Consume(c.queue, tag,
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // args
+	)
+	if err != nil {
+		return errors.Wrapf(err, "can't consume %s", c.opt.Queue)
+	}
+
+	// handlers get a context that is not canceled on stop, so started messages are finished
+	hCtx := context.WithoutCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < c.opt.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				if ctx.Err() != nil {
+					// prefetched, but not started - return to the queue
+					nack(d, true)
+					continue
+				}
+				c.process(hCtx, ch, d)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		goapp.Log.Info().Str("queue", c.opt.Queue).Msg("Draining consumer")
+		if err := ch.Cancel(tag, false); err != nil {
+			goapp.Log.Warn().Err(err).Msg("can't cancel consumer")
+		}
+		<-done
+		return ctx.Err()
+	case <-done:
+		return errors.New("delivery channel closed")
+	}
+}
+
+func (c *Consumer[T]) process(ctx context.Context, ch consumerChannel, d amqp.Delivery) {
+	body, err := readBody(ctx, c.opt.ClaimStore, d)
+	if errors.Is(err, errClaimNotFound) {
+		goapp.Log.Warn().Err(err).Str("queue", c.opt.Queue).Msg("Dropping already processed message copy")
+		ack(d)
+		return
+	}
+	if err != nil {
+		goapp.Log.Error().Err(err).Str("queue", c.opt.Queue).Msg("can't read message")
+		var le *claimLoadError
+		c.fail(ch, d, err, errors.As(err, &le))
+		return
+	}
+	msg, err := c.decode(d.ContentType, body)
+	if err != nil {
+		goapp.Log.Error().Err(err).Str("queue", c.opt.Queue).Msg("can't decode message")
+		c.fail(ch, d, errors.Wrap(err, "malformed message"), false)
+		return
+	}
+	ctx = c.provider.propagator().Extract(ctx, d.Headers)
+	ctx = context.WithValue(ctx, deliveryInfoKey{},
+		DeliveryInfo{Attempt: deliveryCount(d.Headers) + 1, MaxAttempts: c.opt.MaxDeliveries,
+			ReplyTo: d.ReplyTo, CorrelationID: d.CorrelationId, Exchange: d.Exchange, RoutingKey: d.RoutingKey})
+	if err := c.handler(ctx, msg); err != nil {
+		if errors.Is(err, ErrRetryLater) {
+			goapp.Log.Info().Err(err).Str("queue", c.opt.Queue).Msg("Postponing message")
+			c.retryLater(ch, d)
+			return
+		}
+		goapp.Log.Error().Err(err).Str("queue", c.opt.Queue).Msg("can't process message")
+		c.fail(ch, d, err, true)
+		return
+	}
+	ack(d)
+	checkOut(context.WithoutCancel(ctx), c.opt.ClaimStore, d)
+}
+
+func (c *Consumer[T]) decode(contentType string, data []byte) (*T, error) {
+	return decodeMessage[T](c.opt.Registry, c.opt.Codecs, contentType, data)
+}
+
+// decodeMessage decodes the envelope if registry is set, otherwise the codec is selected by contentType.
+// *messages.DecodeError is returned if the envelope holds other message type
+func decodeMessage[T any](registry *messages.Registry, codecs []Codec, contentType string, data []byte) (*T, error) {
+	if registry == nil {
+		codec, err := codecFor(contentType, codecs)
+		if err != nil {
+			return nil, err
+		}
+		var res T
+		if err := codec.Unmarshal(data, &res); err != nil {
+			return nil, err
+		}
+		return &res, nil
+	}
+	env, msg, err := registry.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	res, ok := any(msg).(*T)
+	if !ok {
+		return nil, &messages.DecodeError{Type: env.Type, Version: env.Version,
+			Err: errors.Errorf("got %T, expected %T", msg, res)}
+	}
+	return res, nil
+}
+
+// fail requeues or dead-letters failed message
+func (c *Consumer[T]) fail(ch consumerChannel, d amqp.Delivery, err error, retryable bool) {
+	if c.opt.MaxDeliveries == 0 {
+		nack(d, retryable)
+		return
+	}
+	count := deliveryCount(d.Headers) + 1
+	if !retryable || count >= c.opt.MaxDeliveries {
+		c.deadLetter(ch, d, err)
+		return
+	}
+	key := c.queue
+	if c.opt.RetryDelay > 0 {
+		delay := retryDelay(c.opt.RetryDelay, c.opt.MaxRetryDelay, count)
+		var err error
+		if key, err = c.delays.name(ch, c.queue, delay); err != nil {
+			goapp.Log.Error().Err(err).Msg("can't prepare delay queue")
+			nack(d, true)
+			return
+		}
+		goapp.Log.Info().Str("queue", c.opt.Queue).Int("attempt", count).Msgf("Retry in %v", delay)
+	}
+	p := toPublishing(d)
+	p.Headers[HeaderDeliveryCount] = int32(count)
+	if err := ch.Publish("", key, false, false, p); err != nil {
+		goapp.Log.Error().Err(err).Msg("can't requeue message")
+		nack(d, true)
+		return
+	}
+	ack(d)
+}
+
+// retryLater republishes the message through the delay queue keeping its delivery count
+func (c *Consumer[T]) retryLater(ch consumerChannel, d amqp.Delivery) {
+	delay := c.opt.RetryDelay
+	if delay == 0 {
+		delay = defaultRetryLaterDelay
+	}
+	key, err := c.delays.name(ch, c.queue, delay)
+	if err != nil {
+		goapp.Log.Error().Err(err).Msg("can't prepare delay queue")
+		nack(d, true)
+		return
+	}
+	if err := ch.Publish("", key, false, false, toPublishing(d)); err != nil {
+		goapp.Log.Error().Err(err).Msg("can't postpone message")
+		nack(d, true)
+		return
+	}
+	ack(d)
+}
+
+func (c *Consumer[T]) deadLetter(ch consumerChannel, d amqp.Delivery, err error) {
+	goapp.Log.Warn().Str("queue", c.opt.Queue).Msg("moving message to dead-letter queue")
+	p := toPublishing(d)
+	p.Headers[HeaderFailureReason] = err.Error()
+	p.Headers[HeaderFailedQueue] = c.queue
+	if err := ch.Publish(DeadLetterExchange(c.queue), DeadLetterQueue(c.queue), false, false, p); err != nil {
+		goapp.Log.Error().Err(err).Msg("can't publish to dead-letter queue")
+		// the broker dead-letters rejected message by the queue arguments, just without the reason
+		nack(d, false)
+		return
+	}
+	ack(d)
+}
+
+// consumerChannel is a part of amqp.Channel used for message republishing
+type consumerChannel interface {
+	queueDeclarer
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+func ack(d amqp.Delivery) {
+	if err := d.Ack(false); err != nil {
+		goapp.Log.Error().Err(err).Msg("can't ack message")
+	}
+}
+
+func nack(d amqp.Delivery, requeue bool) {
+	if err := d.Nack(false, requeue); err != nil {
+		goapp.Log.Error().Err(err).Msg("can't nack message")
+	}
+}
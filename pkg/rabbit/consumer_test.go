@@ -0,0 +1,326 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConsumer(t *testing.T) {
+	h := func(ctx context.Context, msg *messages.QueueMessage) error { return nil }
+	type args struct {
+		provider *ChannelProvider
+		opt      ConsumerOptions
+		handler  HandlerFunc[messages.QueueMessage]
+	}
+	tests := []struct {
+		name         string
+		args         args
+		wantWorkers  int
+		wantPrefetch int
+		wantErr      bool
+	}{
+		{name: "OK", args: args{provider: &ChannelProvider{}, opt: ConsumerOptions{Queue: "q", Workers: 2, Prefetch: 4}, handler: h},
+			wantWorkers: 2, wantPrefetch: 4},
+		{name: "Defaults", args: args{provider: &ChannelProvider{}, opt: ConsumerOptions{Queue: "q"}, handler: h},
+			wantWorkers: 1, wantPrefetch: 1},
+		{name: "Prefetch default", args: args{provider: &ChannelProvider{}, opt: ConsumerOptions{Queue: "q", Workers: 3}, handler: h},
+			wantWorkers: 3, wantPrefetch: 3},
+		{name: "No provider", args: args{opt: ConsumerOptions{Queue: "q"}, handler: h}, wantErr: true},
+		{name: "No queue", args: args{provider: &ChannelProvider{}, handler: h}, wantErr: true},
+		{name: "No handler", args: args{provider: &ChannelProvider{}, opt: ConsumerOptions{Queue: "q"}}, wantErr: true},
+		{name: "Delay without max", args: args{provider: &ChannelProvider{}, opt: ConsumerOptions{Queue: "q",
+			RetryDelay: time.Second}, handler: h}, wantErr: true},
+		{name: "Wrong workers", args: args{provider: &ChannelProvider{}, opt: ConsumerOptions{Queue: "q", Workers: -1}, handler: h},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewConsumer(tt.args.provider, tt.args.opt, tt.args.handler)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewConsumer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantWorkers, got.opt.Workers)
+				assert.Equal(t, tt.wantPrefetch, got.opt.Prefetch)
+			}
+		})
+	}
+}
+
+func TestConsumer_process(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		handlerErr  error
+		wantCalled  bool
+		wantAck     int
+		wantNack    int
+		wantRequeue bool
+	}{
+		{name: "OK", body: `{"id":"1"}`, wantCalled: true, wantAck: 1},
+		{name: "Handler fails", body: `{"id":"1"}`, handlerErr: errors.New("olia"), wantCalled: true, wantNack: 1,
+			wantRequeue: true},
+		{name: "Malformed", body: `{"id":`, wantNack: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q"},
+				func(ctx context.Context, msg *messages.QueueMessage) error {
+					called = true
+					assert.Equal(t, "1", msg.ID)
+					info, _ := DeliveryInfoFromContext(ctx)
+					assert.Equal(t, "job.1.finished", info.RoutingKey)
+					return tt.handlerErr
+				})
+			ack := &testAcknowledger{}
+			pub := &testPublisher{}
+			c.process(test.Ctx(t), pub, amqp.Delivery{Acknowledger: ack, Body: []byte(tt.body), RoutingKey: "job.1.finished"})
+			assert.Empty(t, pub.msgs)
+			assert.Equal(t, tt.wantCalled, called)
+			assert.Equal(t, tt.wantAck, ack.acks)
+			assert.Equal(t, tt.wantNack, ack.nacks)
+			assert.Equal(t, tt.wantRequeue, ack.requeue)
+		})
+	}
+}
+
+func TestConsumer_process_DeadLetter(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		count      interface{}
+		pubErr     error
+		wantKey    string
+		wantEx     string
+		wantCount  int
+		wantReason bool
+		wantAck    int
+		wantNack   int
+	}{
+		{name: "Redeliver", body: `{"id":"1"}`, wantKey: "q", wantCount: 1, wantAck: 1},
+		{name: "Redeliver again", body: `{"id":"1"}`, count: int32(1), wantKey: "q", wantCount: 2, wantAck: 1},
+		{name: "Over limit", body: `{"id":"1"}`, count: int32(2), wantEx: "q.dlx", wantKey: "q.dlq", wantCount: 2,
+			wantReason: true, wantAck: 1},
+		{name: "Malformed", body: `{"id":`, wantEx: "q.dlx", wantKey: "q.dlq", wantReason: true, wantAck: 1},
+		{name: "Publish fails", body: `{"id":`, pubErr: errors.New("olia"), wantEx: "q.dlx", wantKey: "q.dlq",
+			wantReason: true, wantNack: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q", MaxDeliveries: 3},
+				func(ctx context.Context, msg *messages.QueueMessage) error {
+					return errors.New("olia")
+				})
+			ack := &testAcknowledger{}
+			pub := &testPublisher{err: tt.pubErr}
+			d := amqp.Delivery{Acknowledger: ack, Body: []byte(tt.body)}
+			if tt.count != nil {
+				d.Headers = amqp.Table{HeaderDeliveryCount: tt.count}
+			}
+			c.process(test.Ctx(t), pub, d)
+			assert.Equal(t, tt.wantAck, ack.acks)
+			assert.Equal(t, tt.wantNack, ack.nacks)
+			assert.False(t, ack.requeue)
+			if assert.Equal(t, 1, len(pub.msgs)) {
+				m := pub.msgs[0]
+				assert.Equal(t, tt.wantEx, m.exchange)
+				assert.Equal(t, tt.wantKey, m.key)
+				assert.Equal(t, tt.wantCount, deliveryCount(m.msg.Headers))
+				assert.Equal(t, tt.wantReason, m.msg.Headers[HeaderFailureReason] != nil)
+				assert.Equal(t, tt.body, string(m.msg.Body))
+			}
+		})
+	}
+}
+
+func TestConsumer_process_Delayed(t *testing.T) {
+	tests := []struct {
+		name         string
+		count        interface{}
+		declareErr   error
+		wantKey      string
+		wantAttempt  int
+		wantDeclared int
+		wantAck      int
+		wantNack     int
+	}{
+		{name: "First", wantKey: "q.delay.1000", wantAttempt: 1, wantDeclared: 1, wantAck: 1},
+		{name: "Second", count: int32(1), wantKey: "q.delay.2000", wantAttempt: 2, wantDeclared: 1, wantAck: 1},
+		{name: "Limited", count: int32(4), wantKey: "q.delay.5000", wantAttempt: 5, wantDeclared: 1, wantAck: 1},
+		{name: "Declare fails", declareErr: errors.New("olia"), wantAttempt: 1, wantDeclared: 1, wantNack: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info DeliveryInfo
+			c, err := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q", MaxDeliveries: 10,
+				RetryDelay: time.Second, MaxRetryDelay: 5 * time.Second},
+				func(ctx context.Context, msg *messages.QueueMessage) error {
+					info, _ = DeliveryInfoFromContext(ctx)
+					return errors.New("olia")
+				})
+			assert.Nil(t, err)
+			ack := &testAcknowledger{}
+			pub := &testPublisher{declareErr: tt.declareErr}
+			d := amqp.Delivery{Acknowledger: ack, Body: []byte(`{"id":"1"}`)}
+			if tt.count != nil {
+				d.Headers = amqp.Table{HeaderDeliveryCount: tt.count}
+			}
+			c.process(test.Ctx(t), pub, d)
+			assert.Equal(t, tt.wantAttempt, info.Attempt)
+			assert.Equal(t, 10, info.MaxAttempts)
+			assert.Equal(t, tt.wantDeclared, len(pub.declared))
+			assert.Equal(t, tt.wantAck, ack.acks)
+			assert.Equal(t, tt.wantNack, ack.nacks)
+			if tt.wantKey != "" && assert.Equal(t, 1, len(pub.msgs)) {
+				assert.Equal(t, "", pub.msgs[0].exchange)
+				assert.Equal(t, tt.wantKey, pub.msgs[0].key)
+			}
+		})
+	}
+}
+
+func TestConsumer_process_RetryLater(t *testing.T) {
+	tests := []struct {
+		name       string
+		opt        ConsumerOptions
+		declareErr error
+		wantKey    string
+		wantAck    int
+		wantNack   int
+	}{
+		{name: "Default delay", opt: ConsumerOptions{Queue: "q"}, wantKey: "q.delay.5000", wantAck: 1},
+		{name: "Retry delay", opt: ConsumerOptions{Queue: "q", MaxDeliveries: 3, RetryDelay: time.Second},
+			wantKey: "q.delay.1000", wantAck: 1},
+		{name: "Declare fails", opt: ConsumerOptions{Queue: "q"}, declareErr: errors.New("olia"), wantNack: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConsumer(&ChannelProvider{}, tt.opt,
+				func(ctx context.Context, msg *messages.QueueMessage) error {
+					return fmt.Errorf("olia: %w", ErrRetryLater)
+				})
+			assert.Nil(t, err)
+			ack := &testAcknowledger{}
+			pub := &testPublisher{declareErr: tt.declareErr}
+			// the last attempt is not dead-lettered and not counted
+			c.process(test.Ctx(t), pub, amqp.Delivery{Acknowledger: ack, Body: []byte(`{"id":"1"}`),
+				Headers: amqp.Table{HeaderDeliveryCount: int32(2)}})
+			assert.Equal(t, tt.wantAck, ack.acks)
+			assert.Equal(t, tt.wantNack, ack.nacks)
+			if tt.wantKey != "" && assert.Equal(t, 1, len(pub.msgs)) {
+				assert.Equal(t, tt.wantKey, pub.msgs[0].key)
+				assert.Equal(t, int32(2), pub.msgs[0].msg.Headers[HeaderDeliveryCount])
+			}
+		})
+	}
+}
+
+func TestConsumer_process_Registry(t *testing.T) {
+	r := messages.NewRegistry("test")
+	assert.Nil(t, r.Register("queue", 1, &messages.QueueMessage{}, nil))
+	assert.Nil(t, r.Register("inform", 1, &messages.InformMessage{}, nil))
+	tests := []struct {
+		name       string
+		body       string
+		wantCalled bool
+		wantAck    int
+		wantNack   int
+	}{
+		{name: "OK", body: `{"type":"queue","version":1,"payload":{"id":"1"}}`, wantCalled: true, wantAck: 1},
+		{name: "Other type", body: `{"type":"inform","version":1,"payload":{"id":"1"}}`, wantNack: 1},
+		{name: "Unknown version", body: `{"type":"queue","version":2,"payload":{"id":"1"}}`, wantNack: 1},
+		{name: "No envelope", body: `{"id":"1"}`, wantNack: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q", Registry: r},
+				func(ctx context.Context, msg *messages.QueueMessage) error {
+					called = true
+					assert.Equal(t, "1", msg.ID)
+					return nil
+				})
+			ack := &testAcknowledger{}
+			c.process(test.Ctx(t), &testPublisher{}, amqp.Delivery{Acknowledger: ack, Body: []byte(tt.body)})
+			assert.Equal(t, tt.wantCalled, called)
+			assert.Equal(t, tt.wantAck, ack.acks)
+			assert.Equal(t, tt.wantNack, ack.nacks)
+			assert.False(t, ack.requeue)
+		})
+	}
+}
+
+func TestDeliveryInfo_RetriesLeft(t *testing.T) {
+	assert.Equal(t, -1, DeliveryInfo{Attempt: 3}.RetriesLeft())
+	assert.Equal(t, 2, DeliveryInfo{Attempt: 1, MaxAttempts: 3}.RetriesLeft())
+	assert.Equal(t, 0, DeliveryInfo{Attempt: 3, MaxAttempts: 3}.RetriesLeft())
+}
+
+type testPublished struct {
+	exchange, key string
+	msg           amqp.Publishing
+}
+
+type testPublisher struct {
+	msgs       []testPublished
+	err        error
+	declared   []string
+	declareErr error
+}
+
+func (p *testPublisher) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	p.declared = append(p.declared, name)
+	return amqp.Queue{Name: name}, p.declareErr
+}
+
+func (p *testPublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.msgs = append(p.msgs, testPublished{exchange: exchange, key: key, msg: msg})
+	return p.err
+}
+
+type testAcknowledger struct {
+	acks, nacks int
+	requeue     bool
+}
+
+func (a *testAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *testAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacks++
+	a.requeue = requeue
+	return nil
+}
+
+func (a *testAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestConsumer_Start_StopsWhileReconnecting(t *testing.T) {
+	pr, err := NewChannelProviderWithOptions(ProviderOptions{URL: "localhost:1"})
+	assert.Nil(t, err)
+	c, err := NewConsumer(pr, ConsumerOptions{Queue: "q"},
+		func(ctx context.Context, msg *messages.QueueMessage) error { return nil })
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(test.Ctx(t))
+	done := c.Start(ctx)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("consumer did not stop")
+	}
+}
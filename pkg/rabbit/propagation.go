@@ -0,0 +1,125 @@
+package rabbit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// HeaderTraceID keeps the trace ID of the message
+	HeaderTraceID = "x-trace-id"
+	// HeaderTraceParent keeps W3C trace context traceparent value
+	HeaderTraceParent = "traceparent"
+	// HeaderMetadataPrefix is the prefix of metadata headers: x-meta-<key>
+	HeaderMetadataPrefix = "x-meta-"
+)
+
+// Propagator moves context values into message headers on send and back into the handler context on consume.
+// Implement it to plug in other tracing libraries, see ProviderOptions.Propagator
+type Propagator interface {
+	Inject(ctx context.Context, headers amqp.Table)
+	Extract(ctx context.Context, headers amqp.Table) context.Context
+}
+
+// HeaderPropagator is the default Propagator, it passes trace ID, traceparent and metadata
+type HeaderPropagator struct{}
+
+type traceIDKey struct{}
+type traceParentKey struct{}
+type metadataKey struct{}
+
+// Inject implements Propagator
+func (HeaderPropagator) Inject(ctx context.Context, headers amqp.Table) {
+	if v := TraceID(ctx); v != "" {
+		headers[HeaderTraceID] = v
+	}
+	if v := TraceParent(ctx); v != "" {
+		headers[HeaderTraceParent] = v
+	}
+	for k, v := range Metadata(ctx) {
+		headers[HeaderMetadataPrefix+k] = v
+	}
+}
+
+// Extract implements Propagator
+func (HeaderPropagator) Extract(ctx context.Context, headers amqp.Table) context.Context {
+	if v, ok := headers[HeaderTraceID].(string); ok && v != "" {
+		ctx = WithTraceID(ctx, v)
+	}
+	if v, ok := headers[HeaderTraceParent].(string); ok && v != "" {
+		ctx = WithTraceParent(ctx, v)
+	}
+	md := map[string]string{}
+	for k, v := range headers {
+		if s, ok := v.(string); ok && strings.HasPrefix(k, HeaderMetadataPrefix) {
+			md[strings.TrimPrefix(k, HeaderMetadataPrefix)] = s
+		}
+	}
+	if len(md) > 0 {
+		ctx = withMetadata(ctx, md)
+	}
+	return ctx
+}
+
+// WithTraceID returns context with the trace ID
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID from context. If it is not set, the trace ID part of traceparent is returned
+func TraceID(ctx context.Context) string {
+	if res, ok := ctx.Value(traceIDKey{}).(string); ok && res != "" {
+		return res
+	}
+	if parts := strings.Split(TraceParent(ctx), "-"); len(parts) == 4 {
+		return parts[1]
+	}
+	return ""
+}
+
+// WithTraceParent returns context with W3C traceparent value: 00-<trace-id>-<parent-id>-<flags>
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParent returns W3C traceparent value from context
+func TraceParent(ctx context.Context) string {
+	res, _ := ctx.Value(traceParentKey{}).(string)
+	return res
+}
+
+// NewTrace returns context with a new random trace ID and traceparent, use it where the job starts
+func NewTrace(ctx context.Context) context.Context {
+	traceID, parentID := randomHex(16), randomHex(8)
+	return WithTraceParent(WithTraceID(ctx, traceID), "00-"+traceID+"-"+parentID+"-01")
+}
+
+// WithMetadata returns context with the key/value added to the propagated metadata
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	md := map[string]string{}
+	for k, v := range Metadata(ctx) {
+		md[k] = v
+	}
+	md[key] = value
+	return withMetadata(ctx, md)
+}
+
+func withMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// Metadata returns propagated metadata from context, the map must not be modified
+func Metadata(ctx context.Context) map[string]string {
+	res, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return res
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package rabbit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderPropagator_Roundtrip(t *testing.T) {
+	ctx := WithTraceID(test.Ctx(t), "trace")
+	ctx = WithTraceParent(ctx, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx = WithMetadata(ctx, "customer", "olia")
+	h := amqp.Table{}
+	HeaderPropagator{}.Inject(ctx, h)
+	assert.Equal(t, amqp.Table{HeaderTraceID: "trace",
+		HeaderTraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"x-meta-customer": "olia"}, h)
+
+	got := HeaderPropagator{}.Extract(context.Background(), h)
+	assert.Equal(t, "trace", TraceID(got))
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", TraceParent(got))
+	assert.Equal(t, map[string]string{"customer": "olia"}, Metadata(got))
+}
+
+func TestHeaderPropagator_Empty(t *testing.T) {
+	h := amqp.Table{}
+	HeaderPropagator{}.Inject(test.Ctx(t), h)
+	assert.Empty(t, h)
+	ctx := test.Ctx(t)
+	assert.Equal(t, ctx, HeaderPropagator{}.Extract(ctx, amqp.Table{"x-other": "1"}))
+}
+
+func TestTraceID_FromTraceParent(t *testing.T) {
+	ctx := WithTraceParent(test.Ctx(t), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", TraceID(ctx))
+	assert.Equal(t, "", TraceID(WithTraceParent(test.Ctx(t), "olia")))
+}
+
+func TestNewTrace(t *testing.T) {
+	ctx := NewTrace(test.Ctx(t))
+	assert.Equal(t, 32, len(TraceID(ctx)))
+	assert.Equal(t, 55, len(TraceParent(ctx)))
+	assert.Equal(t, "00-"+TraceID(ctx), TraceParent(ctx)[:35])
+}
+
+func TestWithMetadata_DoesNotModifyParent(t *testing.T) {
+	ctx := WithMetadata(test.Ctx(t), "a", "1")
+	ctx2 := WithMetadata(ctx, "b", "2")
+	assert.Equal(t, map[string]string{"a": "1"}, Metadata(ctx))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, Metadata(ctx2))
+}
+
+func TestConsumer_process_ExtractsTrace(t *testing.T) {
+	var traceID string
+	c, _ := NewConsumer(&ChannelProvider{}, ConsumerOptions{Queue: "q"},
+		func(ctx context.Context, msg *messages.QueueMessage) error {
+			traceID = TraceID(ctx)
+			return nil
+		})
+	c.process(test.Ctx(t), &testPublisher{}, amqp.Delivery{Acknowledger: &testAcknowledger{},
+		Body: []byte(`{"id":"1"}`), Headers: amqp.Table{HeaderTraceID: "olia"}})
+	assert.Equal(t, "olia", traceID)
+}
@@ -0,0 +1,178 @@
+package rabbit
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNack is returned when the broker does not accept the published message
+	ErrNack = errors.New("message nacked by broker")
+	// ErrUnroutable is returned when the broker can't route the mandatory message to any queue
+	ErrUnroutable = errors.New("message unroutable")
+)
+
+// pubChannel is a publishing channel used by one caller at a time.
+// In confirm mode it publishes mandatory messages and waits for the broker's answer
+type pubChannel struct {
+	ch       *amqp.Channel
+	confirm  bool
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	timeout  time.Duration
+	broken   bool
+	closed   atomic.Bool
+}
+
+func newPubChannel(ch *amqp.Channel, confirm bool, timeout time.Duration) (*pubChannel, error) {
+	res := &pubChannel{ch: ch, confirm: confirm, timeout: timeout}
+	if confirm {
+		if err := ch.Confirm(false); err != nil {
+			return nil, errors.Wrap(err, "can't set confirm mode")
+		}
+		res.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+		res.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err := <-closed; err != nil {
+			goapp.Log.Warn().Err(err).Msg("rabbit publish channel closed")
+		}
+		res.closed.Store(true)
+	}()
+	return res, nil
+}
+
+// usable returns false if the channel is closed or is in unknown state
+func (pc *pubChannel) usable() bool {
+	return !pc.broken && !pc.closed.Load()
+}
+
+// publish sends the message and waits for confirm in confirm mode
+func (pc *pubChannel) publish(exchange, key string, msg amqp.Publishing) error {
+	if err := pc.ch.Publish(exchange, key, pc.confirm, false, msg); err != nil {
+		pc.broken = true
+		return err
+	}
+	if !pc.confirm {
+		return nil
+	}
+	err := pc.wait()
+	if err != nil && !errors.Is(err, ErrNack) && !errors.Is(err, ErrUnroutable) {
+		// late confirm would be mixed with the next message's one
+		pc.broken = true
+	}
+	return err
+}
+
+func (pc *pubChannel) wait() error {
+	timer := time.NewTimer(pc.timeout)
+	defer timer.Stop()
+	var ret *amqp.Return
+	for {
+		select {
+		case r, ok := <-pc.returns:
+			if !ok {
+				return errors.New("channel closed")
+			}
+			ret = &r
+		case c, ok := <-pc.confirms:
+			if !ok {
+				return errors.New("channel closed")
+			}
+			if !c.Ack {
+				return ErrNack
+			}
+			// broker sends return before ack, so it is already queued if any
+			if ret == nil {
+				select {
+				case r, ok := <-pc.returns:
+					if ok {
+						ret = &r
+					}
+				default:
+				}
+			}
+			if ret != nil {
+				return errors.Wrapf(ErrUnroutable, "%s/%s: %d %s", ret.Exchange, ret.RoutingKey, ret.ReplyCode, ret.ReplyText)
+			}
+			return nil
+		case <-timer.C:
+			return errors.Errorf("no confirm in %v", pc.timeout)
+		}
+	}
+}
+
+func (pc *pubChannel) close() {
+	if pc.ch != nil {
+		_ = pc.ch.Close()
+	}
+}
+
+// channelPool keeps idle publishing channels and limits the number of open ones
+type channelPool struct {
+	idle   chan *pubChannel
+	tokens chan struct{}
+}
+
+func newChannelPool(size int) *channelPool {
+	return &channelPool{idle: make(chan *pubChannel, size), tokens: make(chan struct{}, size)}
+}
+
+// get returns idle channel, opens a new one if the limit allows or waits for a free one
+func (p *channelPool) get(open func() (*pubChannel, error)) (*pubChannel, error) {
+	for {
+		select {
+		case pc := <-p.idle:
+			if pc.usable() {
+				return pc, nil
+			}
+			p.discard(pc)
+		default:
+			select {
+			case pc := <-p.idle:
+				if pc.usable() {
+					return pc, nil
+				}
+				p.discard(pc)
+			case p.tokens <- struct{}{}:
+				pc, err := open()
+				if err != nil {
+					<-p.tokens
+					return nil, err
+				}
+				return pc, nil
+			}
+		}
+	}
+}
+
+// put returns channel to the pool, closed or broken channels are dropped
+func (p *channelPool) put(pc *pubChannel) {
+	if !pc.usable() {
+		p.discard(pc)
+		return
+	}
+	p.idle <- pc
+}
+
+func (p *channelPool) discard(pc *pubChannel) {
+	pc.close()
+	<-p.tokens
+}
+
+// closeIdle closes all idle channels
+func (p *channelPool) closeIdle() {
+	for {
+		select {
+		case pc := <-p.idle:
+			p.discard(pc)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package rabbit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pubChannel_wait(t *testing.T) {
+	tests := []struct {
+		name    string
+		ret     bool
+		confirm *amqp.Confirmation
+		wantErr error
+	}{
+		{name: "Ack", confirm: &amqp.Confirmation{Ack: true}},
+		{name: "Nack", confirm: &amqp.Confirmation{Ack: false}, wantErr: ErrNack},
+		{name: "Unroutable", ret: true, confirm: &amqp.Confirmation{Ack: true}, wantErr: ErrUnroutable},
+		{name: "Timeout", wantErr: errors.New("no confirm in 10ms")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := &pubChannel{confirms: make(chan amqp.Confirmation, 1), returns: make(chan amqp.Return, 1),
+				timeout: 10 * time.Millisecond}
+			if tt.ret {
+				pc.returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+			}
+			if tt.confirm != nil {
+				pc.confirms <- *tt.confirm
+			}
+			err := pc.wait()
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else if errors.Is(tt.wantErr, ErrNack) || errors.Is(tt.wantErr, ErrUnroutable) {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.EqualError(t, err, tt.wantErr.Error())
+			}
+		})
+	}
+}
+
+func Test_pubChannel_wait_Closed(t *testing.T) {
+	pc := &pubChannel{confirms: make(chan amqp.Confirmation, 1), returns: make(chan amqp.Return, 1),
+		timeout: time.Second}
+	close(pc.confirms)
+	assert.NotNil(t, pc.wait())
+}
+
+func Test_channelPool_Reuses(t *testing.T) {
+	p := newChannelPool(2)
+	opened := 0
+	open := func() (*pubChannel, error) {
+		opened++
+		return &pubChannel{}, nil
+	}
+	pc, err := p.get(open)
+	assert.Nil(t, err)
+	p.put(pc)
+	pc2, err := p.get(open)
+	assert.Nil(t, err)
+	assert.Same(t, pc, pc2)
+	assert.Equal(t, 1, opened)
+}
+
+func Test_channelPool_DropsClosed(t *testing.T) {
+	p := newChannelPool(1)
+	opened := 0
+	open := func() (*pubChannel, error) {
+		opened++
+		return &pubChannel{}, nil
+	}
+	pc, _ := p.get(open)
+	p.put(pc)
+	pc.closed.Store(true)
+	pc2, err := p.get(open)
+	assert.Nil(t, err)
+	assert.NotSame(t, pc, pc2)
+	assert.Equal(t, 2, opened)
+
+	pc2.broken = true
+	p.put(pc2)
+	_, err = p.get(open)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, opened)
+}
+
+func Test_channelPool_OpenFail(t *testing.T) {
+	p := newChannelPool(1)
+	_, err := p.get(func() (*pubChannel, error) { return nil, errors.New("olia") })
+	assert.NotNil(t, err)
+	_, err = p.get(func() (*pubChannel, error) { return &pubChannel{}, nil })
+	assert.Nil(t, err)
+}
+
+func Test_channelPool_Limits(t *testing.T) {
+	p := newChannelPool(1)
+	open := func() (*pubChannel, error) { return &pubChannel{}, nil }
+	pc, _ := p.get(open)
+	got := make(chan *pubChannel)
+	go func() {
+		res, _ := p.get(open)
+		got <- res
+	}()
+	select {
+	case <-got:
+		t.Fatal("unexpected channel")
+	case <-time.After(10 * time.Millisecond):
+	}
+	p.put(pc)
+	select {
+	case res := <-got:
+		assert.Same(t, pc, res)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
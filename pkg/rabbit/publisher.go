@@ -1,6 +1,9 @@
 package rabbit
 
 import (
+	"context"
+
+	"github.com/airenas/async-api/pkg/messages"
 	"github.com/airenas/go-app/pkg/goapp"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
@@ -9,6 +12,15 @@ import (
 //Publisher publish events to rabbit mq broker
 type Publisher struct {
 	ChannelProvider *ChannelProvider
+	codecs          codecs
+}
+
+//PublisherOptions keeps Publisher settings
+type PublisherOptions struct {
+	// Codec encodes events, defaults to JSONCodec
+	Codec Codec
+	// ExchangeCodecs overrides Codec per exchange, keys are exchange names without prefix
+	ExchangeCodecs map[string]Codec
 }
 
 //NewPublisher initializes rabbit publisher
@@ -16,22 +28,60 @@ func NewPublisher(provider *ChannelProvider) *Publisher {
 	return &Publisher{ChannelProvider: provider}
 }
 
+//NewPublisherWithOptions initializes rabbit publisher with options
+func NewPublisherWithOptions(provider *ChannelProvider, opt PublisherOptions) (*Publisher, error) {
+	if provider == nil {
+		return nil, errors.New("no channel provider")
+	}
+	return &Publisher{ChannelProvider: provider, codecs: codecs{def: opt.Codec, byTarget: opt.ExchangeCodecs}}, nil
+}
+
 //Publish publish the message
 func (sender *Publisher) Publish(id string, topic string) error {
 	realTopic := sender.ChannelProvider.QueueName(topic)
 	goapp.Log.Info().Msgf("Publishing event %s(%s)", realTopic, id)
 
-	err := sender.ChannelProvider.RunOnChannelWithRetry(func(ch *amqp.Channel) error {
-		return ch.Publish(
-			realTopic, // exchange
-			"",
-			false, // mandatory
-			false,
-			amqp.Publishing{
-				ContentType: "text/plain",
-				Body:        []byte(id),
-			})
-	})
+	err := sender.ChannelProvider.Publish(
+		realTopic, // exchange
+		"",
+		amqp.Publishing{
+			ContentType: "text/plain",
+			Body:        []byte(id),
+		})
+	if err != nil {
+		return errors.Wrap(err, "can't publish event")
+	}
+	return nil
+}
+
+//PublishEvent publishes the message encoded with the exchange's codec to the exchange with the routing key, see RoutingKey.
+//In confirm mode the error is ErrUnroutable if no queue is bound for the key
+func (sender *Publisher) PublishEvent(message messages.Message, exchange string, routingKey string) error {
+	return sender.PublishEventWithContext(context.Background(), message, exchange, routingKey)
+}
+
+//PublishEventWithContext publishes the event, trace info and metadata from ctx are passed in headers
+func (sender *Publisher) PublishEventWithContext(ctx context.Context, message messages.Message, exchange string,
+	routingKey string) error {
+	realExchange := sender.ChannelProvider.QueueName(exchange)
+	goapp.Log.Info().Msgf("Publishing event %s(%s)", realExchange, routingKey)
+
+	codec := sender.codecs.get(exchange)
+	msgBytes, err := codec.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal message")
+	}
+	headers := amqp.Table{}
+	sender.ChannelProvider.propagator().Inject(ctx, headers)
+	err = sender.ChannelProvider.Publish(
+		realExchange,
+		routingKey,
+		amqp.Publishing{
+			Headers:      headers,
+			DeliveryMode: amqp.Persistent,
+			ContentType:  codec.ContentType(),
+			Body:         msgBytes,
+		})
 	if err != nil {
 		return errors.Wrap(err, "can't publish event")
 	}
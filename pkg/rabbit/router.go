@@ -0,0 +1,42 @@
+package rabbit
+
+import (
+	"context"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/go-app/pkg/goapp"
+)
+
+// RouteByTag returns handler calling the route selected by the message's tag value.
+// def is called if there is no route for the value, nil def skips (acks) such messages.
+// Use it e.g. for per-customer priority lanes
+func RouteByTag[T any, PT interface {
+	*T
+	messages.Tagged
+}](key string, routes map[string]HandlerFunc[T], def HandlerFunc[T]) HandlerFunc[T] {
+	return func(ctx context.Context, msg *T) error {
+		v, _ := PT(msg).Tag(key)
+		h, ok := routes[v]
+		if !ok {
+			h = def
+		}
+		if h == nil {
+			goapp.Log.Debug().Str("tag", key).Str("value", v).Msg("no route, skip message")
+			return nil
+		}
+		return h(ctx, msg)
+	}
+}
+
+// FilterByTag returns handler calling next only for messages with the tag value in values,
+// other messages are skipped (acked)
+func FilterByTag[T any, PT interface {
+	*T
+	messages.Tagged
+}](key string, values []string, next HandlerFunc[T]) HandlerFunc[T] {
+	routes := make(map[string]HandlerFunc[T], len(values))
+	for _, v := range values {
+		routes[v] = next
+	}
+	return RouteByTag[T, PT](key, routes, nil)
+}
@@ -0,0 +1,53 @@
+package rabbit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteByTag(t *testing.T) {
+	var called string
+	h := func(name string) HandlerFunc[messages.QueueMessage] {
+		return func(ctx context.Context, msg *messages.QueueMessage) error {
+			called = name
+			return nil
+		}
+	}
+	tests := []struct {
+		name string
+		tags []messages.Tag
+		def  HandlerFunc[messages.QueueMessage]
+		want string
+	}{
+		{name: "Route", tags: []messages.Tag{{Key: "priority", Value: "high"}}, want: "high"},
+		{name: "Default", tags: []messages.Tag{{Key: "priority", Value: "olia"}}, def: h("def"), want: "def"},
+		{name: "No tag", def: h("def"), want: "def"},
+		{name: "Skip", tags: []messages.Tag{{Key: "priority", Value: "olia"}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = ""
+			r := RouteByTag("priority", map[string]HandlerFunc[messages.QueueMessage]{"high": h("high"), "low": h("low")}, tt.def)
+			assert.Nil(t, r(test.Ctx(t), &messages.QueueMessage{Tags: tt.tags}))
+			assert.Equal(t, tt.want, called)
+		})
+	}
+}
+
+func TestFilterByTag(t *testing.T) {
+	called := 0
+	f := FilterByTag("customer", []string{"a", "b"}, func(ctx context.Context, msg *messages.InformMessage) error {
+		called++
+		return nil
+	})
+	for _, c := range []string{"a", "b", "c", ""} {
+		m := &messages.InformMessage{}
+		m.SetTag("customer", c)
+		assert.Nil(t, f(test.Ctx(t), m))
+	}
+	assert.Equal(t, 2, called)
+}
@@ -0,0 +1,210 @@
+package rabbit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/airenas/go-app/pkg/goapp"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// RPCClient sends requests and waits for the replies on its exclusive reply queue.
+// Replies are matched to the calls by correlation ID
+type RPCClient struct {
+	provider *ChannelProvider
+	sender   *Sender
+	opt      RPCClientOptions
+
+	m          sync.Mutex // struct field mutex
+	ch         *amqp.Channel
+	replyQueue string
+	pending    map[string]chan amqp.Delivery
+}
+
+// RPCClientOptions keeps RPCClient settings
+type RPCClientOptions struct {
+	// Timeout is used for calls if ctx has no deadline
+	Timeout time.Duration
+	// Sender configures requests' encoding, see SenderOptions.
+	// If Sender.Registry is set, replies must be envelopes of messages.QueueMessage, see Sender.Reply
+	Sender SenderOptions
+	// Codecs decode replies with custom content types, Sender.Codec is added to them
+	Codecs []Codec
+	// ClaimStore loads checked-in replies, defaults to Sender.ClaimStore
+	ClaimStore ClaimStore
+}
+
+// NewRPCClient creates RPC client, timeout is used for calls if ctx has no deadline
+func NewRPCClient(provider *ChannelProvider, timeout time.Duration) (*RPCClient, error) {
+	return NewRPCClientWithOptions(provider, RPCClientOptions{Timeout: timeout})
+}
+
+// NewRPCClientWithOptions creates RPC client with options
+func NewRPCClientWithOptions(provider *ChannelProvider, opt RPCClientOptions) (*RPCClient, error) {
+	if provider == nil {
+		return nil, errors.New("no channel provider")
+	}
+	if opt.Timeout <= 0 {
+		return nil, errors.Errorf("wrong timeout %v", opt.Timeout)
+	}
+	sender, err := NewSenderWithOptions(provider, opt.Sender)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Sender.Codec != nil {
+		opt.Codecs = append(append([]Codec{}, opt.Codecs...), opt.Sender.Codec)
+	}
+	if opt.ClaimStore == nil {
+		opt.ClaimStore = opt.Sender.ClaimStore
+	}
+	return &RPCClient{provider: provider, sender: sender, opt: opt,
+		pending: map[string]chan amqp.Delivery{}}, nil
+}
+
+// Call sends the message to the queue and waits for the reply
+func (c *RPCClient) Call(ctx context.Context, message messages.Message, queue string) (*messages.QueueMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opt.Timeout)
+		defer cancel()
+	}
+	corrID := uuid.NewString()
+	res := make(chan amqp.Delivery, 1)
+	replyQueue, err := c.register(ctx, corrID, res)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unregister(corrID)
+
+	if err := c.sender.sendWithCorr(ctx, message, queue, replyQueue, corrID); err != nil {
+		return nil, err
+	}
+	select {
+	case d, ok := <-res:
+		if !ok {
+			return nil, errors.New("reply queue closed")
+		}
+		return c.decodeReply(ctx, d)
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "no reply from %s", queue)
+	}
+}
+
+func (c *RPCClient) decodeReply(ctx context.Context, d amqp.Delivery) (*messages.QueueMessage, error) {
+	body, err := readBody(ctx, c.opt.ClaimStore, d)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read reply")
+	}
+	// replies are auto-acked, the body is not needed anymore
+	checkOut(context.WithoutCancel(ctx), c.opt.ClaimStore, d)
+	res, err := decodeMessage[messages.QueueMessage](c.opt.Sender.Registry, c.opt.Codecs, d.ContentType, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode reply")
+	}
+	return res, nil
+}
+
+// Close closes the reply channel, pending calls fail
+func (c *RPCClient) Close() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.ch != nil {
+		_ = c.ch.Close()
+	}
+}
+
+// register adds pending call and returns reply queue, the queue is declared on first call
+func (c *RPCClient) register(ctx context.Context, corrID string, res chan amqp.Delivery) (string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.ch == nil {
+		if err := c.listen(ctx); err != nil {
+			return "", errors.Wrap(err, "can't init reply queue")
+		}
+	}
+	c.pending[corrID] = res
+	return c.replyQueue, nil
+}
+
+// unregister removes the call, abandoned or finished
+func (c *RPCClient) unregister(corrID string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	delete(c.pending, corrID)
+}
+
+// listen must be called under lock
+func (c *RPCClient) listen(ctx context.Context) error {
+	ch, err := c.provider.ConsumerChannelContext(ctx)
+	if err != nil {
+		return err
+	}
+	q, err := ch.QueueDeclare(
+		"",    // server generated name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		_ = ch.Close()
+		return errors.Wrap(err, "can't declare reply queue")
+	}
+	deliveries, err := ch.Consume(q.Name, "",
+		true,  // auto-ack
+		true,  // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // args
+	)
+	if err != nil {
+		_ = ch.Close()
+		return errors.Wrap(err, "can't consume reply queue")
+	}
+	goapp.Log.Info().Str("queue", q.Name).Msg("Listening for replies")
+	c.ch, c.replyQueue = ch, q.Name
+	go func() {
+		for d := range deliveries {
+			c.dispatch(d)
+		}
+		c.closed(ch)
+	}()
+	return nil
+}
+
+func (c *RPCClient) dispatch(d amqp.Delivery) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	res, ok := c.pending[d.CorrelationId]
+	if !ok {
+		goapp.Log.Warn().Str("corrID", d.CorrelationId).Msg("reply for unknown call, dropping")
+		return
+	}
+	delete(c.pending, d.CorrelationId)
+	res <- d
+}
+
+// closed fails pending calls, their replies can't come to the new queue
+func (c *RPCClient) closed(ch *amqp.Channel) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.ch != ch {
+		return
+	}
+	goapp.Log.Warn().Str("queue", c.replyQueue).Msg("Reply queue closed")
+	for k, res := range c.pending {
+		close(res)
+		delete(c.pending, k)
+	}
+	c.ch, c.replyQueue = nil, ""
+}
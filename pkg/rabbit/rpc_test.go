@@ -0,0 +1,128 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/internal/pkg/test"
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRPCClient(t *testing.T) {
+	got, err := NewRPCClient(&ChannelProvider{}, time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, got)
+	_, err = NewRPCClient(nil, time.Second)
+	assert.NotNil(t, err)
+	_, err = NewRPCClient(&ChannelProvider{}, 0)
+	assert.NotNil(t, err)
+}
+
+func TestNewRPCClientWithOptions(t *testing.T) {
+	got, err := NewRPCClientWithOptions(&ChannelProvider{}, RPCClientOptions{Timeout: time.Second,
+		Sender: SenderOptions{Codec: MsgPackCodec{}}, Codecs: []Codec{testCodec{}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Codec{testCodec{}, MsgPackCodec{}}, got.opt.Codecs)
+	_, err = NewRPCClientWithOptions(&ChannelProvider{}, RPCClientOptions{Timeout: time.Second,
+		Sender: SenderOptions{Compression: "olia"}})
+	assert.NotNil(t, err)
+}
+
+func TestRPCClient_decodeReply(t *testing.T) {
+	b, err := testCodec{}.Marshal(&messages.QueueMessage{ID: "1"})
+	assert.Nil(t, err)
+	d := amqp.Delivery{ContentType: testCodec{}.ContentType(), Body: b}
+
+	c, _ := NewRPCClientWithOptions(&ChannelProvider{}, RPCClientOptions{Timeout: time.Second,
+		Codecs: []Codec{testCodec{}}})
+	got, err := c.decodeReply(test.Ctx(t), d)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", got.ID)
+
+	c, _ = NewRPCClient(&ChannelProvider{}, time.Second)
+	_, err = c.decodeReply(test.Ctx(t), d)
+	assert.NotNil(t, err)
+}
+
+func TestRPCClient_decodeReply_Registry(t *testing.T) {
+	r := messages.NewRegistry("test")
+	assert.Nil(t, r.Register("queue", 1, &messages.QueueMessage{}, nil))
+	assert.Nil(t, r.Register("inform", 1, &messages.InformMessage{}, nil))
+	c, _ := NewRPCClientWithOptions(&ChannelProvider{}, RPCClientOptions{Timeout: time.Second,
+		Sender: SenderOptions{Registry: r}})
+	tests := []struct {
+		name    string
+		body    string
+		wantID  string
+		wantErr bool
+	}{
+		{name: "OK", body: `{"type":"queue","version":1,"payload":{"id":"1"}}`, wantID: "1"},
+		{name: "No envelope", body: `{"id":"1"}`, wantErr: true},
+		{name: "Other type", body: `{"type":"inform","version":1,"payload":{"id":"1"}}`, wantErr: true},
+		{name: "Unknown version", body: `{"type":"queue","version":2,"payload":{"id":"1"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.decodeReply(test.Ctx(t), amqp.Delivery{ContentType: "application/json",
+				Body: []byte(tt.body)})
+			if tt.wantErr {
+				var de *messages.DecodeError
+				assert.True(t, errors.As(err, &de))
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantID, got.ID)
+		})
+	}
+}
+
+func TestRPCClient_dispatch(t *testing.T) {
+	c, _ := NewRPCClient(&ChannelProvider{}, time.Second)
+	res := make(chan amqp.Delivery, 1)
+	c.pending["1"] = res
+
+	c.dispatch(amqp.Delivery{CorrelationId: "2", Body: []byte("olia")})
+	assert.Empty(t, res)
+	c.dispatch(amqp.Delivery{CorrelationId: "1", Body: []byte("olia")})
+	assert.Equal(t, "olia", string((<-res).Body))
+	assert.Empty(t, c.pending)
+	// late duplicate is dropped
+	c.dispatch(amqp.Delivery{CorrelationId: "1", Body: []byte("olia")})
+	assert.Empty(t, res)
+}
+
+func TestRPCClient_closed(t *testing.T) {
+	c, _ := NewRPCClient(&ChannelProvider{}, time.Second)
+	ch := &amqp.Channel{}
+	c.ch, c.replyQueue = ch, "amq.gen-1"
+	res := make(chan amqp.Delivery, 1)
+	c.pending["1"] = res
+
+	c.closed(&amqp.Channel{})
+	assert.Equal(t, 1, len(c.pending))
+
+	c.closed(ch)
+	_, ok := <-res
+	assert.False(t, ok)
+	assert.Empty(t, c.pending)
+	assert.Nil(t, c.ch)
+	assert.Equal(t, "", c.replyQueue)
+}
+
+func TestRPCClient_unregister(t *testing.T) {
+	c, _ := NewRPCClient(&ChannelProvider{}, time.Second)
+	c.pending["1"] = make(chan amqp.Delivery, 1)
+	c.unregister("1")
+	assert.Empty(t, c.pending)
+}
+
+func TestSender_Reply_NoContext(t *testing.T) {
+	s := NewSender(&ChannelProvider{})
+	assert.NotNil(t, s.Reply(test.Ctx(t), &messages.QueueMessage{ID: "1"}))
+	ctx := context.WithValue(test.Ctx(t), deliveryInfoKey{}, DeliveryInfo{Attempt: 1})
+	assert.NotNil(t, s.Reply(ctx, &messages.QueueMessage{ID: "1"}))
+}
@@ -1,7 +1,9 @@
 package rabbit
 
 import (
+	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/airenas/async-api/pkg/messages"
 	"github.com/airenas/go-app/pkg/goapp"
@@ -12,6 +14,26 @@ import (
 //Sender performs messages sending using rabbit mq broker
 type Sender struct {
 	ChannelProvider *ChannelProvider
+	delays          delayQueues
+	opt             SenderOptions
+	codecs          codecs
+}
+
+//SenderOptions keeps Sender settings
+type SenderOptions struct {
+	// Registry wraps messages into versioned envelopes
+	Registry *messages.Registry
+	// Compression compresses message bodies, consumers decompress them by ContentEncoding
+	Compression Compression
+	// ClaimStore keeps bodies larger than ClaimCheckSize, the message carries only the reference.
+	// Consumers must be configured with the same store
+	ClaimStore ClaimStore
+	// ClaimCheckSize is the max body size (after compression) sent through the broker, defaults to 1MB
+	ClaimCheckSize int
+	// Codec encodes messages, defaults to JSONCodec
+	Codec Codec
+	// QueueCodecs overrides Codec per queue, keys are queue names without prefix
+	QueueCodecs map[string]Codec
 }
 
 type initFunc func(*ChannelProvider) error
@@ -21,42 +43,159 @@ func NewSender(provider *ChannelProvider) *Sender {
 	return &Sender{ChannelProvider: provider}
 }
 
+//NewSenderWithRegistry initializes rabbit sender, messages are sent wrapped into versioned envelopes
+func NewSenderWithRegistry(provider *ChannelProvider, registry *messages.Registry) *Sender {
+	return &Sender{ChannelProvider: provider, opt: SenderOptions{Registry: registry}}
+}
+
+//NewSenderWithOptions initializes rabbit sender with options
+func NewSenderWithOptions(provider *ChannelProvider, opt SenderOptions) (*Sender, error) {
+	if provider == nil {
+		return nil, errors.New("no channel provider")
+	}
+	if err := opt.Compression.validate(); err != nil {
+		return nil, err
+	}
+	if opt.ClaimCheckSize < 0 {
+		return nil, errors.Errorf("wrong claim check size %d", opt.ClaimCheckSize)
+	}
+	if opt.ClaimCheckSize == 0 {
+		opt.ClaimCheckSize = 1024 * 1024
+	}
+	res := &Sender{ChannelProvider: provider, opt: opt, codecs: codecs{def: opt.Codec, byTarget: opt.QueueCodecs}}
+	if opt.Registry != nil && !res.codecs.onlyJSON() {
+		return nil, errors.New("registry requires JSON codec")
+	}
+	return res, nil
+}
+
 //Send sends the message
 func (sender *Sender) Send(message messages.Message, queue string, replyQueue string) error {
 	return sender.SendWithCorr(message, queue, replyQueue, "")
 }
 
-//SendWithCorr sends the message with correlationID
+//SendWithContext sends the message, trace info and metadata from ctx are passed in headers
+func (sender *Sender) SendWithContext(ctx context.Context, message messages.Message, queue string, replyQueue string) error {
+	return sender.sendWithCorr(ctx, message, queue, replyQueue, "")
+}
+
+//SendWithCorr sends the message with correlationID, QueueName is applied to the queue and the replyQueue
 func (sender *Sender) SendWithCorr(message messages.Message, queue string, replyQueue string, corrID string) error {
-	realQueue := sender.ChannelProvider.QueueName(queue)
+	return sender.sendWithCorr(context.Background(), message, queue, replyQueue, corrID)
+}
+
+func (sender *Sender) sendWithCorr(ctx context.Context, message messages.Message, queue string, replyQueue string,
+	corrID string) error {
+	realQueue, msg, err := sender.Prepare(ctx, message, queue, replyQueue)
+	if err != nil {
+		return err
+	}
+	msg.CorrelationId = corrID
 	goapp.Log.Debug().Msgf("Sending message to %s", realQueue)
 
-	msgBytes, err := getBytes(message)
+	err = sender.ChannelProvider.Publish(
+		"", // exchange
+		realQueue,
+		msg)
 	if err != nil {
-		return errors.Wrap(err, "can't marshal message")
+		return errors.Wrap(err, "Can't send message")
 	}
+	return nil
+}
+
+//Prepare encodes the message as Send does, but does not send it.
+//It returns the prefixed queue name and the message, use it to store messages for sending later
+func (sender *Sender) Prepare(ctx context.Context, message messages.Message, queue string,
+	replyQueue string) (string, amqp.Publishing, error) {
+	msg, err := sender.publishing(ctx, message, queue)
+	if err != nil {
+		return "", amqp.Publishing{}, err
+	}
+	msg.ReplyTo = sender.ChannelProvider.QueueName(replyQueue)
+	return sender.ChannelProvider.QueueName(queue), msg, nil
+}
 
+//Reply sends the reply to the request being processed by Consumer handler, ctx must be the handler's one
+func (sender *Sender) Reply(ctx context.Context, message messages.Message) error {
+	info, ok := DeliveryInfoFromContext(ctx)
+	if !ok || info.ReplyTo == "" {
+		return errors.New("no reply queue in context")
+	}
+	return sender.sendWithCorr(ctx, message, info.ReplyTo, "", info.CorrelationID)
+}
+
+func (sender *Sender) headers(ctx context.Context) amqp.Table {
+	res := amqp.Table{}
+	sender.ChannelProvider.propagator().Inject(ctx, res)
+	return res
+}
+
+//SendDelayed sends the message to the queue after the delay.
+//The message waits in the <queue>.delay.<ms> queue, which is declared on first use
+func (sender *Sender) SendDelayed(message messages.Message, queue string, delay time.Duration) error {
+	if delay <= 0 {
+		return sender.Send(message, queue, "")
+	}
+	realQueue := sender.ChannelProvider.QueueName(queue)
+	goapp.Log.Debug().Msgf("Sending message to %s, delay %v", realQueue, delay)
+
+	msg, err := sender.publishing(context.Background(), message, queue)
+	if err != nil {
+		return err
+	}
+
+	var delayQueue string
 	err = sender.ChannelProvider.RunOnChannelWithRetry(func(ch *amqp.Channel) error {
-		return ch.Publish(
-			"", // exchange
-			realQueue,
-			false, // mandatory
-			false,
-			amqp.Publishing{
-				DeliveryMode:  amqp.Persistent,
-				ContentType:   "application/json",
-				Body:          msgBytes,
-				ReplyTo:       replyQueue,
-				CorrelationId: corrID,
-			})
+		var err error
+		delayQueue, err = sender.delays.name(ch, realQueue, delay)
+		return err
 	})
 	if err != nil {
-		return errors.Wrap(err, "Can't send message")
+		return errors.Wrap(err, "can't declare delay queue")
+	}
+	err = sender.ChannelProvider.Publish(
+		"", // exchange
+		delayQueue,
+		msg)
+	if err != nil {
+		return errors.Wrap(err, "Can't send delayed message")
 	}
 	return nil
 }
 
-func getBytes(msg messages.Message) ([]byte, error) {
+// publishing prepares persistent message: encodes with the queue's codec, compresses and checks in the large body
+func (sender *Sender) publishing(ctx context.Context, message messages.Message, queue string) (amqp.Publishing, error) {
+	codec := sender.codecs.get(queue)
+	msgBytes, err := sender.encode(message, codec)
+	if err != nil {
+		return amqp.Publishing{}, errors.Wrap(err, "can't marshal message")
+	}
+	if msgBytes, err = compress(sender.opt.Compression, msgBytes); err != nil {
+		return amqp.Publishing{}, err
+	}
+	res := amqp.Publishing{
+		Headers:         sender.headers(ctx),
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     codec.ContentType(),
+		ContentEncoding: string(sender.opt.Compression),
+		Body:            msgBytes,
+	}
+	if sender.opt.ClaimStore != nil && len(msgBytes) > sender.opt.ClaimCheckSize {
+		if err := checkIn(ctx, sender.opt.ClaimStore, &res); err != nil {
+			return amqp.Publishing{}, err
+		}
+	}
+	return res, nil
+}
+
+func (sender *Sender) encode(msg messages.Message, codec Codec) ([]byte, error) {
+	if sender.opt.Registry != nil {
+		return sender.opt.Registry.Encode(msg)
+	}
+	return codec.Marshal(msg)
+}
+
+func getBytes(msg any) ([]byte, error) {
 	res, err := json.Marshal(msg)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't marshal message")
@@ -1,9 +1,12 @@
 package rabbit
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/airenas/async-api/internal/pkg/test"
 	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,3 +16,72 @@ func TestGetBytes_Simple(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "{\"id\":\"id\",\"error\":\"err\"}", string(b))
 }
+
+func TestSender_publishing(t *testing.T) {
+	store := &testClaimStore{data: map[string][]byte{}}
+	tests := []struct {
+		name         string
+		opt          SenderOptions
+		id           string
+		wantEncoding string
+		wantClaim    bool
+	}{
+		{name: "Plain", id: "1"},
+		{name: "Gzip", opt: SenderOptions{Compression: CompressionGzip}, id: "1", wantEncoding: "gzip"},
+		{name: "Small", opt: SenderOptions{ClaimStore: store, ClaimCheckSize: 100}, id: "1"},
+		{name: "Claim", opt: SenderOptions{ClaimStore: store, ClaimCheckSize: 100}, id: strings.Repeat("1", 100),
+			wantClaim: true},
+		{name: "Claim zstd", opt: SenderOptions{Compression: CompressionZstd, ClaimStore: store, ClaimCheckSize: 10},
+			id: strings.Repeat("1", 100), wantEncoding: "zstd", wantClaim: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSenderWithOptions(&ChannelProvider{}, tt.opt)
+			assert.Nil(t, err)
+			got, err := s.publishing(test.Ctx(t), &messages.QueueMessage{ID: tt.id}, "q")
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantEncoding, got.ContentEncoding)
+			_, claim := got.Headers[HeaderClaimCheck]
+			assert.Equal(t, tt.wantClaim, claim)
+			assert.Equal(t, tt.wantClaim, len(got.Body) == 0)
+
+			body, err := readBody(test.Ctx(t), store, amqp.Delivery{Headers: got.Headers,
+				ContentEncoding: got.ContentEncoding, Body: got.Body})
+			assert.Nil(t, err)
+			assert.Equal(t, `{"id":"`+tt.id+`"}`, string(body))
+		})
+	}
+}
+
+func TestNewSenderWithOptions(t *testing.T) {
+	s, err := NewSenderWithOptions(&ChannelProvider{}, SenderOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1024*1024, s.opt.ClaimCheckSize)
+	_, err = NewSenderWithOptions(nil, SenderOptions{})
+	assert.NotNil(t, err)
+	_, err = NewSenderWithOptions(&ChannelProvider{}, SenderOptions{Compression: "olia"})
+	assert.NotNil(t, err)
+	_, err = NewSenderWithOptions(&ChannelProvider{}, SenderOptions{ClaimCheckSize: -1})
+	assert.NotNil(t, err)
+}
+
+func TestSender_publishing_Codec(t *testing.T) {
+	s, err := NewSenderWithOptions(&ChannelProvider{}, SenderOptions{
+		QueueCodecs: map[string]Codec{"mp": MsgPackCodec{}}})
+	assert.Nil(t, err)
+	got, err := s.publishing(test.Ctx(t), &messages.QueueMessage{ID: "1"}, "mp")
+	assert.Nil(t, err)
+	assert.Equal(t, ContentTypeMsgPack, got.ContentType)
+	var msg messages.QueueMessage
+	assert.Nil(t, MsgPackCodec{}.Unmarshal(got.Body, &msg))
+	assert.Equal(t, "1", msg.ID)
+
+	got, err = s.publishing(test.Ctx(t), &messages.QueueMessage{ID: "1"}, "q")
+	assert.Nil(t, err)
+	assert.Equal(t, ContentTypeJSON, got.ContentType)
+	assert.Equal(t, `{"id":"1"}`, string(got.Body))
+
+	_, err = NewSenderWithOptions(&ChannelProvider{}, SenderOptions{Registry: messages.NewRegistry(""),
+		QueueCodecs: map[string]Codec{"mp": MsgPackCodec{}}})
+	assert.NotNil(t, err)
+}
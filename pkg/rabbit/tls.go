@@ -0,0 +1,51 @@
+package rabbit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// externalAuth implements amqp.Authentication for the EXTERNAL SASL mechanism,
+// the broker takes the user from the client certificate
+type externalAuth struct{}
+
+// Mechanism returns SASL mechanism name
+func (a *externalAuth) Mechanism() string {
+	return "EXTERNAL"
+}
+
+// Response returns empty SASL response, identity comes from the TLS connection
+func (a *externalAuth) Response() string {
+	return ""
+}
+
+// NewTLSConfig prepares TLS config for amqps connection.
+// caFile is a PEM file with CA certificates to verify the broker, system pool is used if empty.
+// certFile and keyFile are PEM files of the client certificate for mutual TLS, optional
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	res := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read CA file %s", caFile)
+		}
+		res.RootCAs = x509.NewCertPool()
+		if !res.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both client cert and key files must be set")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't load client certificate")
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+	return res, nil
+}
@@ -0,0 +1,29 @@
+package rabbit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	wrong := filepath.Join(dir, "wrong.pem")
+	assert.Nil(t, os.WriteFile(wrong, []byte("olia"), 0600))
+
+	got, err := NewTLSConfig("", "", "")
+	assert.Nil(t, err)
+	assert.NotNil(t, got)
+	assert.Nil(t, got.RootCAs)
+
+	_, err = NewTLSConfig(filepath.Join(dir, "missing.pem"), "", "")
+	assert.NotNil(t, err)
+	_, err = NewTLSConfig(wrong, "", "")
+	assert.NotNil(t, err)
+	_, err = NewTLSConfig("", wrong, "")
+	assert.NotNil(t, err)
+	_, err = NewTLSConfig("", wrong, wrong)
+	assert.NotNil(t, err)
+}
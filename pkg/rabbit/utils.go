@@ -1,19 +1,86 @@
 package rabbit
 
-import "github.com/streadway/amqp"
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// HeaderDeliveryCount keeps the number of failed processing attempts of the message
+	HeaderDeliveryCount = "x-async-delivery-count"
+	// HeaderFailureReason keeps the error text of a dead-lettered message
+	HeaderFailureReason = "x-async-failure-reason"
+	// HeaderFailedQueue keeps the queue name the message was dead-lettered from
+	HeaderFailedQueue = "x-async-failed-queue"
+)
+
+// QueueOptions keeps optional queue declaration settings
+type QueueOptions struct {
+	// DeadLetter declares <queue>.dlx exchange with bound <queue>.dlq queue
+	// and routes rejected messages there
+	DeadLetter bool
+}
+
+// DeadLetterExchange returns the dead-letter exchange name for the queue
+func DeadLetterExchange(qName string) string {
+	return qName + ".dlx"
+}
+
+// DeadLetterQueue returns the dead-letter queue name for the queue
+func DeadLetterQueue(qName string) string {
+	return qName + ".dlq"
+}
 
 //DeclareQueue decrares durable queue
 func DeclareQueue(ch *amqp.Channel, qName string) (amqp.Queue, error) {
+	return DeclareQueueWithOptions(ch, qName, QueueOptions{})
+}
+
+//DeclareQueueWithOptions decrares durable queue with optional dead-letter topology.
+//Names are used as is, pass ChannelProvider.QueueName(name) to get the prefixed ones.
+//Note that the broker refuses to redeclare an existing queue with other options
+func DeclareQueueWithOptions(ch *amqp.Channel, qName string, opt QueueOptions) (amqp.Queue, error) {
+	var args amqp.Table
+	if opt.DeadLetter {
+		if err := declareDeadLetter(ch, qName); err != nil {
+			return amqp.Queue{}, err
+		}
+		args = amqp.Table{
+			"x-dead-letter-exchange":    DeadLetterExchange(qName),
+			"x-dead-letter-routing-key": DeadLetterQueue(qName),
+		}
+	}
 	return ch.QueueDeclare(
 		qName,
 		true,  // durable
 		false, // delete when unused
 		false, // exclusive
 		false, // no-wait
-		nil,   // arguments
+		args,  // arguments
 	)
 }
 
+func declareDeadLetter(ch *amqp.Channel, qName string) error {
+	dlx, dlq := DeadLetterExchange(qName), DeadLetterQueue(qName)
+	if err := ch.ExchangeDeclare(dlx, "direct", true, false, false, false, nil); err != nil {
+		return errors.Wrapf(err, "can't declare exchange %s", dlx)
+	}
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return errors.Wrapf(err, "can't declare queue %s", dlq)
+	}
+	if err := ch.QueueBind(dlq, dlq, dlx, false, nil); err != nil {
+		return errors.Wrapf(err, "can't bind queue %s", dlq)
+	}
+	return nil
+}
+
 //NewChannel creates channel to listen from rabbit with auto ack = false
 func NewChannel(ch *amqp.Channel, qName string) (<-chan amqp.Delivery, error) {
 	return ch.Consume(
@@ -27,15 +94,164 @@ func NewChannel(ch *amqp.Channel, qName string) (<-chan amqp.Delivery, error) {
 	)
 }
 
+const (
+	// ExchangeFanout sends events to all bound queues
+	ExchangeFanout = "fanout"
+	// ExchangeDirect sends events to queues bound with the same routing key
+	ExchangeDirect = "direct"
+	// ExchangeTopic sends events to queues bound with matching pattern, e.g. job.*.finished
+	ExchangeTopic = "topic"
+)
+
 //DeclareExchange creates exchange to publish events
 func DeclareExchange(ch *amqp.Channel, topic string) error {
+	return DeclareExchangeWithKind(ch, topic, ExchangeFanout)
+}
+
+//DeclareExchangeWithKind creates durable exchange of the kind: ExchangeFanout, ExchangeDirect or ExchangeTopic
+func DeclareExchangeWithKind(ch *amqp.Channel, name, kind string) error {
 	return ch.ExchangeDeclare(
-		topic,    // name
-		"fanout", // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
+		name,  // name
+		kind,  // type
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
 	)
 }
+
+//Subscribe declares durable queue and binds it to the exchange with the routing key patterns.
+//Queue is bound with an empty key if no patterns are provided
+func Subscribe(ch *amqp.Channel, exchange, qName string, patterns ...string) (amqp.Queue, error) {
+	q, err := DeclareQueue(ch, qName)
+	if err != nil {
+		return q, errors.Wrapf(err, "can't declare queue %s", qName)
+	}
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+	for _, p := range patterns {
+		if err := ch.QueueBind(qName, p, exchange, false, nil); err != nil {
+			return q, errors.Wrapf(err, "can't bind queue %s to %s(%s)", qName, exchange, p)
+		}
+	}
+	return q, nil
+}
+
+// RoutingKey joins parts into the routing key, dots inside parts are replaced, e.g. job.<id>.finished
+func RoutingKey(parts ...string) string {
+	res := make([]string, len(parts))
+	for i, p := range parts {
+		res[i] = strings.ReplaceAll(p, ".", "_")
+	}
+	return strings.Join(res, ".")
+}
+
+// InformRoutingKey returns job.<id>.<type> routing key for the inform message, e.g. job.1.finished
+func InformRoutingKey(msg *messages.InformMessage) string {
+	return RoutingKey("job", msg.ID, strings.ToLower(msg.Type))
+}
+
+// deliveryCount returns failed attempts count saved in the message headers
+func deliveryCount(h amqp.Table) int {
+	switch v := h[HeaderDeliveryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int8:
+		return int(v)
+	}
+	return 0
+}
+
+// toPublishing makes a copy of delivery for republishing, headers are copied
+func toPublishing(d amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+// DelayQueue returns the delay queue name for the queue
+func DelayQueue(qName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.delay.%d", qName, delay.Milliseconds())
+}
+
+//DeclareDelayQueue declares queue keeping messages for the delay
+//and dead-lettering them back into qName, returns the delay queue name
+func DeclareDelayQueue(ch *amqp.Channel, qName string, delay time.Duration) (string, error) {
+	return declareDelayQueue(ch, qName, delay)
+}
+
+type queueDeclarer interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+}
+
+func declareDelayQueue(ch queueDeclarer, qName string, delay time.Duration) (string, error) {
+	if delay < time.Millisecond {
+		return "", errors.Errorf("wrong delay %v, expected >= 1ms", delay)
+	}
+	name := DelayQueue(qName, delay)
+	_, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": qName,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "can't declare queue %s", name)
+	}
+	return name, nil
+}
+
+// delayQueues remembers declared delay queues, so they are declared once
+type delayQueues struct {
+	declared sync.Map
+}
+
+func (dq *delayQueues) name(ch queueDeclarer, qName string, delay time.Duration) (string, error) {
+	key := DelayQueue(qName, delay)
+	if _, ok := dq.declared.Load(key); ok {
+		return key, nil
+	}
+	res, err := declareDelayQueue(ch, qName, delay)
+	if err != nil {
+		return "", err
+	}
+	dq.declared.Store(key, true)
+	return res, nil
+}
+
+// retryDelay calculates exponential delay for the attempt (1-based), limited by max if max > 0
+func retryDelay(initial, max time.Duration, attempt int) time.Duration {
+	res := initial
+	for i := 1; i < attempt; i++ {
+		if (max > 0 && res >= max) || res > math.MaxInt64/2 {
+			break
+		}
+		res *= 2
+	}
+	if max > 0 && res > max {
+		return max
+	}
+	return res
+}
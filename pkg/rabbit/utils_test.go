@@ -0,0 +1,70 @@
+package rabbit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/airenas/async-api/pkg/messages"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_deliveryCount(t *testing.T) {
+	assert.Equal(t, 0, deliveryCount(nil))
+	assert.Equal(t, 0, deliveryCount(amqp.Table{HeaderDeliveryCount: "1"}))
+	assert.Equal(t, 2, deliveryCount(amqp.Table{HeaderDeliveryCount: int32(2)}))
+	assert.Equal(t, 3, deliveryCount(amqp.Table{HeaderDeliveryCount: int64(3)}))
+}
+
+func Test_toPublishing_CopiesHeaders(t *testing.T) {
+	d := amqp.Delivery{Headers: amqp.Table{"a": "b"}, Body: []byte("olia"), CorrelationId: "c"}
+	p := toPublishing(d)
+	p.Headers["x"] = "y"
+	assert.Equal(t, 1, len(d.Headers))
+	assert.Equal(t, "olia", string(p.Body))
+	assert.Equal(t, "c", p.CorrelationId)
+	assert.Equal(t, amqp.Persistent, p.DeliveryMode)
+}
+
+func TestDeadLetterNames(t *testing.T) {
+	assert.Equal(t, "olia.dlx", DeadLetterExchange("olia"))
+	assert.Equal(t, "olia.dlq", DeadLetterQueue("olia"))
+}
+
+func Test_retryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial time.Duration
+		max     time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{name: "First", initial: time.Second, attempt: 1, want: time.Second},
+		{name: "Third", initial: time.Second, attempt: 3, want: 4 * time.Second},
+		{name: "Limited", initial: time.Second, max: 3 * time.Second, attempt: 3, want: 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryDelay(tt.initial, tt.max, tt.attempt))
+		})
+	}
+}
+
+func Test_retryDelay_NoOverflow(t *testing.T) {
+	assert.Less(t, time.Duration(0), retryDelay(time.Second, 0, 1000))
+}
+
+func TestDelayQueue(t *testing.T) {
+	assert.Equal(t, "olia.delay.1500", DelayQueue("olia", 1500*time.Millisecond))
+}
+
+func TestRoutingKey(t *testing.T) {
+	assert.Equal(t, "job.1.finished", RoutingKey("job", "1", "finished"))
+	assert.Equal(t, "job.1_2.finished", RoutingKey("job", "1.2", "finished"))
+	assert.Equal(t, "", RoutingKey())
+}
+
+func TestInformRoutingKey(t *testing.T) {
+	msg := &messages.InformMessage{QueueMessage: messages.QueueMessage{ID: "id"}, Type: messages.InformTypeFinished}
+	assert.Equal(t, "job.id.finished", InformRoutingKey(msg))
+}